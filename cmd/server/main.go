@@ -1,8 +1,10 @@
 package main
 
 import (
+    "context"
     "log"
     "net/http"
+    "time"
 
     "github.com/gin-gonic/gin"
     "github.com/sirupsen/logrus"
@@ -12,6 +14,7 @@ import (
     "subscription-service/internal/config"
     "subscription-service/internal/database"
     "subscription-service/internal/handlers"
+    "subscription-service/internal/middleware"
     "subscription-service/internal/repository"
     "subscription-service/internal/service"
 
@@ -47,10 +50,29 @@ func main() {
     defer db.Close()
 
     repo := repository.NewSubscriptionRepository(db)
-    svc := service.NewSubscriptionService(repo)
+    catalogRepo := repository.NewCatalogRepository(db)
+    svc := service.NewSubscriptionService(repo, catalogRepo)
     handler := handlers.NewSubscriptionHandler(svc, logger)
 
+    catalogHandler := handlers.NewCatalogHandler(service.NewCatalogService(catalogRepo), logger)
+
+    rateSvc := service.NewExchangeRateService(repository.NewExchangeRateRepository(db))
+    rateHandler := handlers.NewExchangeRateHandler(rateSvc, logger)
+
+    if cfg.ExchangeRates.File != "" {
+        if err := rateSvc.LoadFromFile(context.Background(), cfg.ExchangeRates.File); err != nil {
+            logger.Fatalf("Failed to load exchange rates: %v", err)
+        }
+        logger.Infof("Loaded exchange rates from %s", cfg.ExchangeRates.File)
+    }
+
+    idempotencySvc := service.NewIdempotencyService(repository.NewIdempotencyRepository(db), cfg.Idempotency.TTL)
+    go deleteExpiredIdempotencyKeys(idempotencySvc, logger)
+
     router := gin.Default()
+    router.Use(middleware.RequestContext())
+    router.Use(handlers.Idempotency(idempotencySvc, logger))
+    router.Use(handlers.ErrorHandler(logger))
 
     router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -61,9 +83,36 @@ func main() {
             subscriptions.POST("", handler.CreateSubscription)
             subscriptions.GET("", handler.ListSubscriptions)
             subscriptions.GET("/summary", handler.GetSummary)
+            subscriptions.GET("/summary/timeseries", handler.GetTimeSeries)
+            subscriptions.GET("/upcoming", handler.ListUpcomingCharges)
+            subscriptions.PUT("/by-key", handler.UpsertSubscription)
             subscriptions.GET("/:id", handler.GetSubscription)
             subscriptions.PUT("/:id", handler.UpdateSubscription)
+            subscriptions.PATCH("/:id", handler.PatchSubscription)
             subscriptions.DELETE("/:id", handler.DeleteSubscription)
+            subscriptions.POST("/:id/restore", handler.RestoreSubscription)
+            subscriptions.GET("/:id/history", handler.GetHistory)
+            subscriptions.POST("/:id/prices", handler.SchedulePriceChange)
+        }
+        // Методы коллекции вида /subscriptions:batch
+        api.POST("/subscriptions:method", handler.SubscriptionsMethod)
+
+        api.POST("/imports", handler.ImportSubscriptions)
+
+        services := api.Group("/services")
+        {
+            services.POST("", catalogHandler.CreateService)
+            services.GET("", catalogHandler.ListServices)
+            services.GET("/:id", catalogHandler.GetService)
+            services.PUT("/:id", catalogHandler.UpdateService)
+            services.DELETE("/:id", catalogHandler.DeleteService)
+        }
+
+        admin := api.Group("/admin")
+        {
+            admin.GET("/exchange-rates", rateHandler.ListRates)
+            admin.PUT("/exchange-rates", rateHandler.SaveRates)
+            admin.POST("/subscriptions/purge", handler.PurgeDeletedSubscriptions)
         }
     }
 
@@ -75,4 +124,21 @@ func main() {
     if err := router.Run(":8080"); err != nil {
         logger.Fatalf("Failed to start server: %v", err)
     }
-}
\ No newline at end of file
+}
+
+// deleteExpiredIdempotencyKeys раз в час удаляет ключи идемпотентности с истекшим сроком
+func deleteExpiredIdempotencyKeys(idempotency service.IdempotencyService, logger *logrus.Logger) {
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        deleted, err := idempotency.DeleteExpired(context.Background())
+        if err != nil {
+            logger.Errorf("Failed to delete expired idempotency keys: %v", err)
+            continue
+        }
+        if deleted > 0 {
+            logger.Infof("Deleted %d expired idempotency keys", deleted)
+        }
+    }
+}
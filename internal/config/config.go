@@ -4,15 +4,18 @@ import (
     "log"
     "os"
     "strconv"
+    "time"
 
     "github.com/joho/godotenv"
     "gopkg.in/yaml.v3"
 )
 
 type Config struct {
-    Server   ServerConfig   `yaml:"server"`
-    Database DatabaseConfig `yaml:"database"`
-    Logging  LoggingConfig  `yaml:"logging"`
+    Server        ServerConfig        `yaml:"server"`
+    Database      DatabaseConfig      `yaml:"database"`
+    Logging       LoggingConfig       `yaml:"logging"`
+    ExchangeRates ExchangeRatesConfig `yaml:"exchange_rates"`
+    Idempotency   IdempotencyConfig   `yaml:"idempotency"`
 }
 
 type ServerConfig struct {
@@ -32,6 +35,15 @@ type LoggingConfig struct {
     Level string `yaml:"level"`
 }
 
+type ExchangeRatesConfig struct {
+    File string `yaml:"file"`
+}
+
+// IdempotencyConfig - настройки заголовка Idempotency-Key. TTL задается строкой вида "24h".
+type IdempotencyConfig struct {
+    TTL time.Duration `yaml:"ttl"`
+}
+
 func LoadConfig() (*Config, error) {
     if err := godotenv.Load(); err != nil {
         log.Println("No .env file found")
@@ -72,6 +84,12 @@ func loadConfigFromEnv() *Config {
         Logging: LoggingConfig{
             Level: getEnv("LOG_LEVEL", "info"),
         },
+        ExchangeRates: ExchangeRatesConfig{
+            File: getEnv("EXCHANGE_RATES_FILE", ""),
+        },
+        Idempotency: IdempotencyConfig{
+            TTL: getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+        },
     }
 }
 
@@ -85,6 +103,16 @@ func overrideFromEnv(config *Config) {
     if host := os.Getenv("DB_HOST"); host != "" {
         config.Database.Host = host
     }
+
+    if file := os.Getenv("EXCHANGE_RATES_FILE"); file != "" {
+        config.ExchangeRates.File = file
+    }
+
+    if ttl := os.Getenv("IDEMPOTENCY_TTL"); ttl != "" {
+        if d, err := time.ParseDuration(ttl); err == nil {
+            config.Idempotency.TTL = d
+        }
+    }
 }
 
 func getEnv(key, defaultValue string) string {
@@ -92,4 +120,13 @@ func getEnv(key, defaultValue string) string {
         return value
     }
     return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+    if value := os.Getenv(key); value != "" {
+        if d, err := time.ParseDuration(value); err == nil {
+            return d
+        }
+    }
+    return defaultValue
 }
\ No newline at end of file
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/sirupsen/logrus"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+type CatalogHandler struct {
+    service service.CatalogService
+    logger  *logrus.Logger
+}
+
+func NewCatalogHandler(service service.CatalogService, logger *logrus.Logger) *CatalogHandler {
+    return &CatalogHandler{
+        service: service,
+        logger:  logger,
+    }
+}
+
+// CreateService добавляет сервис в каталог
+// @Summary Создать сервис
+// @Description Добавляет сервис в каталог и привязывает к нему подписки с совпадающим названием или синонимом
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param input body models.ServiceRequest true "Данные сервиса"
+// @Success 201 {object} models.Service
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /services [post]
+func (h *CatalogHandler) CreateService(c *gin.Context) {
+    svc, ok := h.bindService(c)
+    if !ok {
+        return
+    }
+
+    err := h.service.CreateService(c.Request.Context(), svc)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Service created successfully: %s", svc.ID)
+    c.JSON(http.StatusCreated, svc)
+}
+
+// GetService возвращает сервис каталога
+// @Summary Получить сервис
+// @Description Возвращает сервис каталога по его ID
+// @Tags services
+// @Produce json
+// @Param id path string true "ID сервиса"
+// @Success 200 {object} models.Service
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /services/{id} [get]
+func (h *CatalogHandler) GetService(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid service ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    svc, err := h.service.GetService(c.Request.Context(), id)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, svc)
+}
+
+// UpdateService обновляет сервис каталога
+// @Summary Обновить сервис
+// @Description Полностью заменяет данные сервиса каталога
+// @Tags services
+// @Accept json
+// @Produce json
+// @Param id path string true "ID сервиса"
+// @Param input body models.ServiceRequest true "Данные сервиса"
+// @Success 200 {object} models.Service
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /services/{id} [put]
+func (h *CatalogHandler) UpdateService(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid service ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    svc, ok := h.bindService(c)
+    if !ok {
+        return
+    }
+    svc.ID = id
+
+    err = h.service.UpdateService(c.Request.Context(), svc)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Service updated successfully: %s", id)
+    c.JSON(http.StatusOK, svc)
+}
+
+// DeleteService удаляет сервис из каталога
+// @Summary Удалить сервис
+// @Description Удаляет сервис из каталога; подписки сохраняют название и отвязываются от каталога, и это изменение попадает в их историю
+// @Tags services
+// @Produce json
+// @Param id path string true "ID сервиса"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /services/{id} [delete]
+func (h *CatalogHandler) DeleteService(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid service ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    err = h.service.DeleteService(c.Request.Context(), id)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Service deleted successfully: %s", id)
+    c.JSON(http.StatusOK, gin.H{"message": "Service deleted successfully"})
+}
+
+// ListServices возвращает каталог сервисов
+// @Summary Каталог сервисов
+// @Description Возвращает сервисы каталога с поиском по названию и синонимам
+// @Tags services
+// @Produce json
+// @Param q query string false "Поиск по названию и синонимам"
+// @Param category query string false "Категория"
+// @Success 200 {array} models.Service
+// @Failure 500 {object} models.Problem
+// @Router /services [get]
+func (h *CatalogHandler) ListServices(c *gin.Context) {
+    filter := models.ServiceFilter{Query: strings.TrimSpace(c.Query("q"))}
+    if category := c.Query("category"); category != "" {
+        filter.Category = &category
+    }
+
+    services, err := h.service.ListServices(c.Request.Context(), &filter)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, services)
+}
+
+// bindService разбирает тело запроса сервиса каталога; при ошибке ответ уже отправлен
+func (h *CatalogHandler) bindService(c *gin.Context) (*models.Service, bool) {
+    var req models.ServiceRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        h.logger.Warnf("Invalid request body: %v", err)
+        respondInvalidBody(c, err)
+        return nil, false
+    }
+
+    if err := req.DefaultPrices.Validate(); err != nil {
+        h.logger.Warnf("Invalid default prices: %v", err)
+        respondInvalidBody(c, &fieldError{field: "default_prices", message: err.Error()})
+        return nil, false
+    }
+
+    return &models.Service{
+        Name:          strings.TrimSpace(req.Name),
+        Aliases:       req.Aliases,
+        Category:      req.Category,
+        VendorURL:     req.VendorURL,
+        DefaultPrices: req.DefaultPrices,
+        Currency:      req.Currency,
+    }, true
+}
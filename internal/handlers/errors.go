@@ -0,0 +1,63 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/sirupsen/logrus"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+// ErrorHandler отвечает в формате problem+json на ошибки, которые обработчики передали через c.Error.
+// Ошибки предметной области сопоставляются с кодами 404/409/412/422, остальные считаются внутренними (500).
+func ErrorHandler(logger *logrus.Logger) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Next()
+
+        if len(c.Errors) == 0 || c.Writer.Written() {
+            return
+        }
+
+        respondError(c, logger, c.Errors.Last().Err)
+    }
+}
+
+// respondError отвечает на ошибку сервиса кодом, соответствующим ее типу
+func respondError(c *gin.Context, logger *logrus.Logger, err error) {
+    writeProblem(c, errorProblem(c, logger, err))
+}
+
+// errorProblem описывает ошибку сервиса для клиента. Внутренние ошибки записываются в журнал,
+// а клиент получает только общее сообщение.
+func errorProblem(c *gin.Context, logger *logrus.Logger, err error) *models.Problem {
+    status := errorStatus(err)
+    if status == http.StatusInternalServerError {
+        logger.Errorf("Request %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+        return newProblem(c, status, "Internal server error")
+    }
+
+    logger.Warnf("Request %s %s rejected: %v", c.Request.Method, c.Request.URL.Path, err)
+
+    var validationErr *service.ValidationError
+    if errors.As(err, &validationErr) {
+        return newProblem(c, status, "Request failed validation", validationErr.Fields...)
+    }
+    return newProblem(c, status, err.Error())
+}
+
+func errorStatus(err error) int {
+    switch {
+    case errors.Is(err, service.ErrNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, service.ErrConflict):
+        return http.StatusConflict
+    case errors.Is(err, service.ErrPreconditionFailed):
+        return http.StatusPreconditionFailed
+    case errors.Is(err, service.ErrValidation):
+        return http.StatusUnprocessableEntity
+    default:
+        return http.StatusInternalServerError
+    }
+}
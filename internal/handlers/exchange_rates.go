@@ -0,0 +1,92 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/sirupsen/logrus"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+type ExchangeRateHandler struct {
+    service service.ExchangeRateService
+    logger  *logrus.Logger
+}
+
+func NewExchangeRateHandler(service service.ExchangeRateService, logger *logrus.Logger) *ExchangeRateHandler {
+    return &ExchangeRateHandler{
+        service: service,
+        logger:  logger,
+    }
+}
+
+// SaveRates сохраняет курсы валют
+// @Summary Загрузить курсы валют
+// @Description Добавляет или обновляет курсы валют, используемые при пересчете сумм подписок
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param input body []models.ExchangeRate true "Курсы валют"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /admin/exchange-rates [put]
+func (h *ExchangeRateHandler) SaveRates(c *gin.Context) {
+    var rates []models.ExchangeRate
+    if err := c.ShouldBindJSON(&rates); err != nil {
+        h.logger.Warnf("Invalid request body: %v", err)
+        respondInvalidBody(c, err)
+        return
+    }
+
+    var invalid []models.FieldError
+    for i, rate := range rates {
+        if rate.Rate.IsZero() {
+            invalid = append(invalid, models.FieldError{Field: fmt.Sprintf("[%d].rate", i), Message: "must be positive"})
+        }
+        if rate.FromCurrency == rate.ToCurrency {
+            invalid = append(invalid, models.FieldError{Field: fmt.Sprintf("[%d].to_currency", i), Message: "must differ from from_currency"})
+        }
+    }
+    if len(invalid) > 0 {
+        respondProblem(c, http.StatusUnprocessableEntity, "Request body failed validation", invalid...)
+        return
+    }
+
+    if err := h.service.SaveRates(c.Request.Context(), rates); err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Exchange rates saved successfully: %d", len(rates))
+    c.JSON(http.StatusOK, gin.H{"message": "Exchange rates saved successfully"})
+}
+
+// ListRates возвращает курсы валют
+// @Summary Список курсов валют
+// @Description Возвращает загруженные курсы валют
+// @Tags admin
+// @Produce json
+// @Param currency query string false "Код валюты (ISO 4217)"
+// @Success 200 {array} models.ExchangeRate
+// @Failure 500 {object} models.Problem
+// @Router /admin/exchange-rates [get]
+func (h *ExchangeRateHandler) ListRates(c *gin.Context) {
+    var currency *string
+    if currencyStr := c.Query("currency"); currencyStr != "" {
+        currencyStr = strings.ToUpper(currencyStr)
+        currency = &currencyStr
+    }
+
+    rates, err := h.service.ListRates(c.Request.Context(), currency)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, rates)
+}
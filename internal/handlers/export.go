@@ -0,0 +1,269 @@
+package handlers
+
+import (
+    "archive/zip"
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+)
+
+// Форматы ответа списков и сводки
+const (
+    formatJSON   = "json"
+    formatCSV    = "csv"
+    formatNDJSON = "ndjson"
+    formatXLSX   = "xlsx"
+
+    mimeCSV    = "text/csv"
+    mimeNDJSON = "application/x-ndjson"
+    mimeXLSX   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var exportContentTypes = map[string]string{
+    formatCSV:    mimeCSV + "; charset=utf-8",
+    formatNDJSON: mimeNDJSON,
+    formatXLSX:   mimeXLSX,
+}
+
+// exportFormat выбирает формат ответа: параметр format важнее заголовка Accept. Если выгрузку не запросили явно,
+// ответ остается JSON, чтобы не менять поведение для клиентов с Accept: */* или text/html.
+func exportFormat(c *gin.Context) (string, bool) {
+    if format := c.Query("format"); format != "" {
+        switch format {
+        case formatJSON, formatCSV, formatNDJSON, formatXLSX:
+            return format, true
+        default:
+            respondInvalidParam(c, "format", "must be one of: json, csv, ndjson, xlsx")
+            return "", false
+        }
+    }
+
+    switch c.NegotiateFormat(binding.MIMEJSON, mimeCSV, mimeNDJSON, mimeXLSX) {
+    case mimeCSV:
+        return formatCSV, true
+    case mimeNDJSON:
+        return formatNDJSON, true
+    case mimeXLSX:
+        return formatXLSX, true
+    default:
+        return formatJSON, true
+    }
+}
+
+// exportColumn - колонка табличной выгрузки; number - значение записывается в XLSX числом, а не текстом
+type exportColumn struct {
+    name   string
+    number bool
+}
+
+// exportWriter пишет строки выгрузки в ответ по мере их получения. Табличные форматы буферизуют вывод,
+// поэтому до первых нескольких килобайт ответ еще не начат и ошибку можно вернуть обычным образом.
+type exportWriter interface {
+    // Write записывает одну строку: record - значения колонок для CSV и XLSX, item - объект для NDJSON
+    Write(item interface{}, record []string) error
+    // Close дописывает окончание файла; без него XLSX остается поврежденным
+    Close() error
+}
+
+// exportTable описывает выгрузку: имя файла без расширения, название листа XLSX и колонки
+type exportTable struct {
+    name    string
+    sheet   string
+    columns []exportColumn
+}
+
+func newExportWriter(w io.Writer, format string, table *exportTable) (exportWriter, error) {
+    switch format {
+    case formatCSV:
+        return newCSVExportWriter(w, table)
+    case formatNDJSON:
+        return &ndjsonExportWriter{encoder: json.NewEncoder(w)}, nil
+    case formatXLSX:
+        return newXLSXExportWriter(w, table)
+    default:
+        return nil, fmt.Errorf("unsupported export format: %s", format)
+    }
+}
+
+type csvExportWriter struct {
+    writer  *csv.Writer
+    columns []exportColumn
+    record  []string
+}
+
+func newCSVExportWriter(w io.Writer, table *exportTable) (*csvExportWriter, error) {
+    writer := csv.NewWriter(w)
+
+    header := make([]string, len(table.columns))
+    for i, column := range table.columns {
+        header[i] = column.name
+    }
+    // BOM нужен Excel, чтобы открыть файл в UTF-8, а не в кодировке системы
+    header[0] = "\ufeff" + header[0]
+
+    if err := writer.Write(header); err != nil {
+        return nil, err
+    }
+    return &csvExportWriter{writer: writer, columns: table.columns, record: make([]string, len(table.columns))}, nil
+}
+
+func (w *csvExportWriter) Write(item interface{}, record []string) error {
+    for i, value := range record {
+        if !w.columns[i].number {
+            value = escapeFormula(value)
+        }
+        w.record[i] = value
+    }
+    return w.writer.Write(w.record)
+}
+
+func (w *csvExportWriter) Close() error {
+    w.writer.Flush()
+    return w.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+    encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) Write(item interface{}, record []string) error {
+    return w.encoder.Encode(item)
+}
+
+func (w *ndjsonExportWriter) Close() error {
+    return nil
+}
+
+// xlsxExportWriter пишет книгу из одного листа. Лист - последний файл архива, и строки дописываются в него
+// по одной, так что размер выгрузки не ограничен памятью. Строки хранятся в самих ячейках (inlineStr),
+// без общей таблицы строк, которую пришлось бы собрать целиком до записи листа.
+type xlsxExportWriter struct {
+    archive *zip.Writer
+    sheet   *bufio.Writer
+    columns []exportColumn
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+    `<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+    `<Default Extension="xml" ContentType="application/xml"/>` +
+    `<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+    `<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+    `</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+    `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+    `</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+    `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+    `</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+    `<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+    `</workbook>`
+
+const xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const xlsxSheetEnd = `</sheetData></worksheet>`
+
+func newXLSXExportWriter(w io.Writer, table *exportTable) (*xlsxExportWriter, error) {
+    archive := zip.NewWriter(w)
+
+    parts := []struct{ name, content string }{
+        {"[Content_Types].xml", xlsxContentTypes},
+        {"_rels/.rels", xlsxRootRels},
+        {"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(table.sheet))},
+        {"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+    }
+    for _, part := range parts {
+        f, err := archive.Create(part.name)
+        if err != nil {
+            return nil, err
+        }
+        if _, err := io.WriteString(f, part.content); err != nil {
+            return nil, err
+        }
+    }
+
+    f, err := archive.Create("xl/worksheets/sheet1.xml")
+    if err != nil {
+        return nil, err
+    }
+
+    writer := &xlsxExportWriter{archive: archive, sheet: bufio.NewWriter(f), columns: table.columns}
+    if _, err := writer.sheet.WriteString(xlsxSheetStart); err != nil {
+        return nil, err
+    }
+
+    // Заголовок - текстовая строка независимо от типа колонок
+    header := make([]exportColumn, len(table.columns))
+    names := make([]string, len(table.columns))
+    for i, column := range table.columns {
+        header[i] = exportColumn{name: column.name}
+        names[i] = column.name
+    }
+    if err := writer.writeRow(header, names); err != nil {
+        return nil, err
+    }
+    return writer, nil
+}
+
+func (w *xlsxExportWriter) Write(item interface{}, record []string) error {
+    return w.writeRow(w.columns, record)
+}
+
+// writeRow возвращает ошибку записи: bufio.Writer запоминает первую ошибку и возвращает ее при следующих вызовах,
+// поэтому достаточно проверить последний
+func (w *xlsxExportWriter) writeRow(columns []exportColumn, record []string) error {
+    w.sheet.WriteString("<row>")
+    for i, value := range record {
+        switch {
+        case value == "":
+            w.sheet.WriteString("<c/>")
+        case columns[i].number:
+            w.sheet.WriteString("<c><v>" + value + "</v></c>")
+        default:
+            w.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(escapeFormula(value)) + "</t></is></c>")
+        }
+    }
+    _, err := w.sheet.WriteString("</row>")
+    return err
+}
+
+func (w *xlsxExportWriter) Close() error {
+    if _, err := w.sheet.WriteString(xlsxSheetEnd); err != nil {
+        return err
+    }
+    if err := w.sheet.Flush(); err != nil {
+        return err
+    }
+    return w.archive.Close()
+}
+
+// escapeFormula защищает текстовую ячейку от выполнения как формулы: значения от пользователей, например
+// названия сервисов, могут начинаться с =, +, -, @, табуляции или возврата каретки, и таблицы считают
+// такую ячейку формулой. Апостроф в начале оставляет значение текстом.
+func escapeFormula(value string) string {
+    if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+        return "'" + value
+    }
+    return value
+}
+
+func xmlEscape(s string) string {
+    var b strings.Builder
+    xml.EscapeText(&b, []byte(s))
+    return b.String()
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/sirupsen/logrus"
+    "subscription-service/internal/service"
+)
+
+const (
+    IdempotencyKeyHeader     = "Idempotency-Key"
+    IdempotentReplayedHeader = "Idempotent-Replayed"
+    maxIdempotencyKeyLen     = 255
+    // maxIdempotentBodySize ограничивает тело запроса с Idempotency-Key: его приходится прочитать в память,
+    // чтобы посчитать хеш до выполнения запроса. Большие загрузки (POST /imports) отправляются без ключа
+    // и читаются потоком.
+    maxIdempotentBodySize    = 1 << 20
+)
+
+// responseRecorder копирует тело ответа, чтобы его можно было сохранить для повторов
+type responseRecorder struct {
+    gin.ResponseWriter
+    body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+    w.body.Write(data)
+    return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+    w.body.WriteString(s)
+    return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency обрабатывает заголовок Idempotency-Key в POST-запросах. Ключ действует в пределах метода и пути.
+// Первый запрос с ключом выполняется, и его ответ сохраняется; повтор с тем же ключом и телом получает сохраненный
+// ответ без повторного выполнения, а тот же ключ с другим запросом отклоняется с 422. Ответы 5xx не сохраняются,
+// чтобы запрос можно было повторить. Тело больше maxIdempotentBodySize отклоняется с 413.
+// Должен стоять перед ErrorHandler, чтобы сохранялись и ответы об ошибках.
+func Idempotency(idempotency service.IdempotencyService, logger *logrus.Logger) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        key := strings.TrimSpace(c.GetHeader(IdempotencyKeyHeader))
+        if c.Request.Method != http.MethodPost || key == "" {
+            c.Next()
+            return
+        }
+
+        if len(key) > maxIdempotencyKeyLen {
+            respondInvalidParam(c, IdempotencyKeyHeader, "must be at most 255 characters long")
+            return
+        }
+
+        body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            logger.Warnf("Request body with Idempotency-Key %s exceeds %d bytes", key, tooLarge.Limit)
+            respondProblem(c, http.StatusRequestEntityTooLarge,
+                fmt.Sprintf("Requests with Idempotency-Key must not exceed %d bytes; send large uploads without the header", tooLarge.Limit))
+            return
+        }
+        if err != nil {
+            logger.Warnf("Failed to read request body: %v", err)
+            respondProblem(c, http.StatusBadRequest, "Failed to read request body")
+            return
+        }
+        c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+        scope := c.Request.Method + " " + c.Request.URL.Path
+        record, err := idempotency.Begin(c.Request.Context(), scope, key, requestHash(c, body))
+        if err != nil {
+            respondError(c, logger, err)
+            return
+        }
+
+        if record != nil {
+            logger.Infof("Replaying response for Idempotency-Key %s", key)
+            c.Header(IdempotentReplayedHeader, "true")
+            c.Data(*record.StatusCode, record.ContentType, record.Body)
+            c.Abort()
+            return
+        }
+
+        // Сохранение не должно прерываться, если клиент уже отключился
+        ctx := context.WithoutCancel(c.Request.Context())
+
+        // Ключ освобождается и при панике в обработчике, иначе он остался бы занятым до истечения срока
+        completed := false
+        defer func() {
+            if !completed {
+                if err := idempotency.Release(ctx, scope, key); err != nil {
+                    logger.Errorf("Failed to release Idempotency-Key %s: %v", key, err)
+                }
+            }
+        }()
+
+        recorder := &responseRecorder{ResponseWriter: c.Writer}
+        c.Writer = recorder
+
+        c.Next()
+
+        status := c.Writer.Status()
+        if !c.Writer.Written() || status >= http.StatusInternalServerError {
+            return
+        }
+
+        err = idempotency.Complete(ctx, scope, key, status, c.Writer.Header().Get("Content-Type"), recorder.body.Bytes())
+        if err != nil {
+            logger.Errorf("Failed to save response for Idempotency-Key %s: %v", key, err)
+            return
+        }
+        completed = true
+    }
+}
+
+// requestHash отличает запросы с одним ключом: в хеш входят метод, путь, строка запроса и тело
+func requestHash(c *gin.Context, body []byte) string {
+    hash := sha256.New()
+    hash.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+    hash.Write(body)
+    return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/sirupsen/logrus"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+// fakeIdempotencyRepo хранит ключи в памяти с той же логикой резервирования, что и idempotency_keys
+type fakeIdempotencyRepo struct {
+    mu      sync.Mutex
+    records map[string]*models.IdempotencyRecord
+}
+
+func newFakeIdempotencyRepo() *fakeIdempotencyRepo {
+    return &fakeIdempotencyRepo{records: map[string]*models.IdempotencyRecord{}}
+}
+
+func (r *fakeIdempotencyRepo) Reserve(ctx context.Context, scope, key, requestHash string, expiresAt time.Time) (*models.IdempotencyRecord, bool, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if record, ok := r.records[scope+"\n"+key]; ok && record.ExpiresAt.After(time.Now()) {
+        copied := *record
+        return &copied, false, nil
+    }
+    r.records[scope+"\n"+key] = &models.IdempotencyRecord{Scope: scope, Key: key, RequestHash: requestHash, ExpiresAt: expiresAt}
+    return nil, true, nil
+}
+
+func (r *fakeIdempotencyRepo) Complete(ctx context.Context, scope, key string, statusCode int, contentType string, body []byte) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    record := r.records[scope+"\n"+key]
+    record.StatusCode = &statusCode
+    record.ContentType = contentType
+    record.Body = body
+    return nil
+}
+
+func (r *fakeIdempotencyRepo) Release(ctx context.Context, scope, key string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if record, ok := r.records[scope+"\n"+key]; ok && record.StatusCode == nil {
+        delete(r.records, scope+"\n"+key)
+    }
+    return nil
+}
+
+func (r *fakeIdempotencyRepo) DeleteExpired(ctx context.Context) (int64, error) {
+    return 0, nil
+}
+
+// expire переводит все ключи в истекшие
+func (r *fakeIdempotencyRepo) expire() {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    for _, record := range r.records {
+        record.ExpiresAt = time.Now().Add(-time.Second)
+    }
+}
+
+func newTestLogger() *logrus.Logger {
+    logger := logrus.New()
+    logger.SetOutput(io.Discard)
+    return logger
+}
+
+// idempotencyRouter возвращает роутер с Idempotency и ErrorHandler, как в cmd/server. POST /items отвечает 201
+// с номером вызова, POST /fail - 500; handle вызывается перед ответом и может задержать его.
+func idempotencyRouter(repo *fakeIdempotencyRepo, handle func()) (*gin.Engine, *int) {
+    gin.SetMode(gin.TestMode)
+    logger := newTestLogger()
+
+    var mu sync.Mutex
+    calls := 0
+    router := gin.New()
+    router.Use(Idempotency(service.NewIdempotencyService(repo, time.Hour), logger))
+    router.Use(ErrorHandler(logger))
+
+    respond := func(c *gin.Context, status int) {
+        if handle != nil {
+            handle()
+        }
+        if _, err := io.ReadAll(c.Request.Body); err != nil {
+            c.Error(err)
+            return
+        }
+        mu.Lock()
+        calls++
+        n := calls
+        mu.Unlock()
+        c.JSON(status, gin.H{"call": n})
+    }
+    router.POST("/items", func(c *gin.Context) { respond(c, http.StatusCreated) })
+    router.POST("/other", func(c *gin.Context) { respond(c, http.StatusCreated) })
+    router.POST("/fail", func(c *gin.Context) { respond(c, http.StatusInternalServerError) })
+    return router, &calls
+}
+
+func postWithKey(router http.Handler, path, key, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    if key != "" {
+        req.Header.Set(IdempotencyKeyHeader, key)
+    }
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w
+}
+
+func TestIdempotency(t *testing.T) {
+    type request struct {
+        path, key, body string
+        wantStatus      int
+        wantBody        string
+        wantReplayed    bool
+    }
+
+    tests := []struct {
+        name      string
+        requests  []request
+        expire    bool
+        wantCalls int
+    }{
+        {
+            name: "replay returns stored response",
+            requests: []request{
+                {path: "/items", key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"call":1}`},
+                {path: "/items", key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"call":1}`, wantReplayed: true},
+            },
+            wantCalls: 1,
+        },
+        {
+            name: "key reused with different body",
+            requests: []request{
+                {path: "/items", key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated},
+                {path: "/items", key: "k1", body: `{"a":2}`, wantStatus: http.StatusUnprocessableEntity},
+            },
+            wantCalls: 1,
+        },
+        {
+            name: "same key on another path",
+            requests: []request{
+                {path: "/items", key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"call":1}`},
+                {path: "/other", key: "k1", body: `{"a":1}`, wantStatus: http.StatusCreated, wantBody: `{"call":2}`},
+            },
+            wantCalls: 2,
+        },
+        {
+            name: "server errors are not stored",
+            requests: []request{
+                {path: "/fail", key: "k1", body: `{}`, wantStatus: http.StatusInternalServerError},
+                {path: "/fail", key: "k1", body: `{}`, wantStatus: http.StatusInternalServerError},
+            },
+            wantCalls: 2,
+        },
+        {
+            name: "without key",
+            requests: []request{
+                {path: "/items", body: `{"a":1}`, wantStatus: http.StatusCreated},
+                {path: "/items", body: `{"a":1}`, wantStatus: http.StatusCreated},
+            },
+            wantCalls: 2,
+        },
+        {
+            name: "body too large",
+            requests: []request{
+                {path: "/items", key: "k1", body: `"` + strings.Repeat("x", maxIdempotentBodySize) + `"`, wantStatus: http.StatusRequestEntityTooLarge},
+            },
+            wantCalls: 0,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            router, calls := idempotencyRouter(newFakeIdempotencyRepo(), nil)
+
+            for i, req := range tt.requests {
+                w := postWithKey(router, req.path, req.key, req.body)
+                if w.Code != req.wantStatus {
+                    t.Fatalf("request %d: status = %d, want %d (body %s)", i, w.Code, req.wantStatus, w.Body)
+                }
+                if req.wantBody != "" && w.Body.String() != req.wantBody {
+                    t.Fatalf("request %d: body = %s, want %s", i, w.Body, req.wantBody)
+                }
+                if replayed := w.Header().Get(IdempotentReplayedHeader) == "true"; replayed != req.wantReplayed {
+                    t.Fatalf("request %d: replayed = %t, want %t", i, replayed, req.wantReplayed)
+                }
+            }
+            if *calls != tt.wantCalls {
+                t.Fatalf("handler called %d times, want %d", *calls, tt.wantCalls)
+            }
+        })
+    }
+}
+
+func TestIdempotencyExpiredKey(t *testing.T) {
+    repo := newFakeIdempotencyRepo()
+    router, calls := idempotencyRouter(repo, nil)
+
+    if w := postWithKey(router, "/items", "k1", `{"a":1}`); w.Code != http.StatusCreated {
+        t.Fatalf("first request: status = %d, want 201", w.Code)
+    }
+    repo.expire()
+
+    // После истечения срока ключ свободен, в том числе для другого тела
+    w := postWithKey(router, "/items", "k1", `{"a":2}`)
+    if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+        t.Fatalf("request after expiry: status = %d, replayed = %q, want a new 201", w.Code, w.Header().Get(IdempotentReplayedHeader))
+    }
+    if *calls != 2 {
+        t.Fatalf("handler called %d times, want 2", *calls)
+    }
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+    started := make(chan struct{})
+    release := make(chan struct{})
+    var once sync.Once
+    router, calls := idempotencyRouter(newFakeIdempotencyRepo(), func() {
+        once.Do(func() {
+            close(started)
+            <-release
+        })
+    })
+
+    first := make(chan *httptest.ResponseRecorder)
+    go func() {
+        first <- postWithKey(router, "/items", "k1", `{"a":1}`)
+    }()
+    <-started
+
+    if w := postWithKey(router, "/items", "k1", `{"a":1}`); w.Code != http.StatusConflict {
+        t.Fatalf("concurrent request: status = %d, want 409", w.Code)
+    }
+
+    close(release)
+    if w := <-first; w.Code != http.StatusCreated {
+        t.Fatalf("first request: status = %d, want 201", w.Code)
+    }
+    if w := postWithKey(router, "/items", "k1", `{"a":1}`); w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+        t.Fatalf("retry after completion: status = %d, want replayed 201", w.Code)
+    }
+    if *calls != 1 {
+        t.Fatalf("handler called %d times, want 1", *calls)
+    }
+}
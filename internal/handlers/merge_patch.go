@@ -0,0 +1,81 @@
+package handlers
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+
+    "subscription-service/internal/models"
+)
+
+// applyMergePatch накладывает JSON Merge Patch (RFC 7396) на подписку в представлении UpdateSubscriptionRequest
+func applyMergePatch(req *models.UpdateSubscriptionRequest, patch []byte) error {
+    var patchDoc interface{}
+    if err := decodeJSON(patch, &patchDoc); err != nil {
+        return err
+    }
+    patchObj, ok := patchDoc.(map[string]interface{})
+    if !ok {
+        return errors.New("patch must be a JSON object")
+    }
+
+    current, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    var target map[string]interface{}
+    if err := decodeJSON(current, &target); err != nil {
+        return err
+    }
+
+    // Новое название без service_id означает смену сервиса: старая привязка к каталогу не должна его перекрыть
+    if _, renamed := patchObj["service_name"]; renamed {
+        if _, relinked := patchObj["service_id"]; !relinked {
+            delete(target, "service_id")
+        }
+    }
+
+    merged, err := json.Marshal(mergePatch(target, patchObj))
+    if err != nil {
+        return err
+    }
+
+    var result models.UpdateSubscriptionRequest
+    decoder := json.NewDecoder(bytes.NewReader(merged))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&result); err != nil {
+        return err
+    }
+
+    *req = result
+    return nil
+}
+
+// mergePatch возвращает target с наложенным patch по правилам RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+    patchObj, ok := patch.(map[string]interface{})
+    if !ok {
+        return patch
+    }
+
+    targetObj, ok := target.(map[string]interface{})
+    if !ok {
+        targetObj = map[string]interface{}{}
+    }
+
+    for key, value := range patchObj {
+        if value == nil {
+            delete(targetObj, key)
+            continue
+        }
+        targetObj[key] = mergePatch(targetObj[key], value)
+    }
+    return targetObj
+}
+
+// decodeJSON разбирает JSON, сохраняя числа в исходной записи, чтобы суммы не проходили через float64
+func decodeJSON(data []byte, v interface{}) error {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    return decoder.Decode(v)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+    "encoding"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
+    "subscription-service/internal/models"
+    "subscription-service/internal/requestctx"
+)
+
+const problemContentType = "application/problem+json"
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func init() {
+    // В ошибках валидации поля называются так же, как в JSON, чтобы клиент мог подсветить поле формы
+    if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+        v.RegisterTagNameFunc(jsonFieldName)
+    }
+}
+
+// fieldError - ошибка в одном поле запроса, которую не может обнаружить validator
+type fieldError struct {
+    field   string
+    message string
+}
+
+func (e *fieldError) Error() string {
+    return fmt.Sprintf("%s %s", e.field, e.message)
+}
+
+// respondProblem отправляет ответ об ошибке в формате RFC 7807 и прерывает обработку запроса
+func respondProblem(c *gin.Context, status int, detail string, fieldErrors ...models.FieldError) {
+    writeProblem(c, newProblem(c, status, detail, fieldErrors...))
+}
+
+func newProblem(c *gin.Context, status int, detail string, fieldErrors ...models.FieldError) *models.Problem {
+    return &models.Problem{
+        Type:      "about:blank",
+        Title:     http.StatusText(status),
+        Status:    status,
+        Detail:    detail,
+        Instance:  c.Request.URL.Path,
+        RequestID: requestctx.RequestID(c.Request.Context()),
+        Errors:    fieldErrors,
+    }
+}
+
+func writeProblem(c *gin.Context, problem *models.Problem) {
+    c.Header("Content-Type", problemContentType)
+    c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// respondInvalidParam отвечает 400 на неверный параметр пути или строки запроса
+func respondInvalidParam(c *gin.Context, param, message string) {
+    respondProblem(c, http.StatusBadRequest, fmt.Sprintf("Invalid %s", param),
+        models.FieldError{Field: param, Message: message})
+}
+
+// respondInvalidBody отвечает на ошибку разбора или проверки тела запроса:
+// 400, если тело не удалось разобрать, и 422 с перечнем полей, если не прошла проверка значений
+func respondInvalidBody(c *gin.Context, err error) {
+    var (
+        validationErrs validator.ValidationErrors
+        sliceErrs      binding.SliceValidationError
+        fieldErr       *fieldError
+        syntaxErr      *json.SyntaxError
+        typeErr        *json.UnmarshalTypeError
+    )
+
+    switch {
+    case errors.As(err, &validationErrs):
+        respondProblem(c, http.StatusUnprocessableEntity, "Request body failed validation", validationFieldErrors(validationErrs)...)
+    case errors.As(err, &sliceErrs):
+        var fields []models.FieldError
+        for _, itemErr := range sliceErrs {
+            if errors.As(itemErr, &validationErrs) {
+                fields = append(fields, validationFieldErrors(validationErrs)...)
+            }
+        }
+        respondProblem(c, http.StatusUnprocessableEntity, "Request body failed validation", fields...)
+    case errors.As(err, &fieldErr):
+        respondProblem(c, http.StatusUnprocessableEntity, "Request body failed validation",
+            models.FieldError{Field: fieldErr.field, Message: fieldErr.message})
+    case errors.Is(err, io.EOF):
+        respondProblem(c, http.StatusBadRequest, "Request body is empty")
+    case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+        respondProblem(c, http.StatusBadRequest, fmt.Sprintf("Request body is not valid JSON: %v", err))
+    case errors.As(err, &typeErr) && typeErr.Field != "":
+        respondProblem(c, http.StatusBadRequest, "Invalid request body",
+            models.FieldError{Field: typeErr.Field, Rule: "type", Message: fmt.Sprintf("must be a JSON %s", jsonTypeName(typeErr.Type))})
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+        respondProblem(c, http.StatusBadRequest, "Invalid request body",
+            models.FieldError{Field: field, Rule: "unknown", Message: "is not a known field"})
+    default:
+        respondProblem(c, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+    }
+}
+
+// validationFieldErrors переводит ошибки validator в список ошибок полей
+func validationFieldErrors(errs validator.ValidationErrors) []models.FieldError {
+    fields := make([]models.FieldError, 0, len(errs))
+    for _, fe := range errs {
+        // Namespace начинается с имени структуры запроса, клиенту оно не нужно
+        field := fe.Namespace()
+        if i := strings.Index(field, "."); i >= 0 {
+            field = field[i+1:]
+        }
+        fields = append(fields, models.FieldError{
+            Field:   field,
+            Rule:    fe.Tag(),
+            Message: validationMessage(fe),
+        })
+    }
+    return fields
+}
+
+func validationMessage(fe validator.FieldError) string {
+    switch fe.Tag() {
+    case "required":
+        return "is required"
+    case "required_without":
+        return "is required when service_id is not set"
+    case "required_if", "required_unless":
+        return "is required for this operation"
+    case "gt":
+        return fmt.Sprintf("must be greater than %s", fe.Param())
+    case "min":
+        if fe.Kind() == reflect.String {
+            return fmt.Sprintf("must be at least %s characters long", fe.Param())
+        }
+        return fmt.Sprintf("must be at least %s", fe.Param())
+    case "max":
+        if fe.Kind() == reflect.String {
+            return fmt.Sprintf("must be at most %s characters long", fe.Param())
+        }
+        return fmt.Sprintf("must be at most %s", fe.Param())
+    case "oneof":
+        return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+    case "iso4217":
+        return "must be an ISO 4217 currency code"
+    case "url":
+        return "must be a valid URL"
+    default:
+        return fmt.Sprintf("failed the %s check", fe.Tag())
+    }
+}
+
+// jsonFieldName возвращает имя поля структуры в JSON
+func jsonFieldName(field reflect.StructField) string {
+    name := strings.Split(field.Tag.Get("json"), ",")[0]
+    if name == "" || name == "-" {
+        return field.Name
+    }
+    return name
+}
+
+// jsonTypeName называет тип Go так, как его видит клиент API
+func jsonTypeName(t reflect.Type) string {
+    // UUID и даты приходят строками
+    if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+        return "string"
+    }
+
+    switch t.Kind() {
+    case reflect.String:
+        return "string"
+    case reflect.Bool:
+        return "boolean"
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+        reflect.Float32, reflect.Float64:
+        return "number"
+    case reflect.Slice, reflect.Array:
+        return "array"
+    default:
+        return "object"
+    }
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+// SubscriptionsMethod направляет запросы POST /subscriptions:<метод> к обработчику метода.
+// Gin не различает маршруты, отличающиеся только суффиксом после двоеточия, поэтому метод разбирается здесь.
+func (h *SubscriptionHandler) SubscriptionsMethod(c *gin.Context) {
+    switch c.Param("method") {
+    case ":batch":
+        h.BatchSubscriptions(c)
+    default:
+        respondProblem(c, http.StatusNotFound, "Unknown method")
+    }
+}
+
+// BatchSubscriptions выполняет пакет операций над подписками
+// @Summary Пакетное создание, изменение и удаление подписок
+// @Description Выполняет до 1000 операций create/update/delete в одной транзакции. В режиме atomic (по умолчанию) ошибка любой операции откатывает весь пакет, в режиме best_effort успешные операции сохраняются. Ответ всегда 200 со статусом каждой операции: код, который она получила бы отдельным запросом, или 424, если она откатилась из-за ошибки другой операции.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param input body models.BatchRequest true "Операции"
+// @Success 200 {object} models.BatchResult
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions:batch [post]
+func (h *SubscriptionHandler) BatchSubscriptions(c *gin.Context) {
+    var req models.BatchRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        h.logger.Warnf("Invalid request body: %v", err)
+        respondInvalidBody(c, err)
+        return
+    }
+
+    // Неверная операция не отклоняет запрос целиком, а получает свою ошибку в ответе
+    for i := range req.Operations {
+        var validationErrs validator.ValidationErrors
+        if err := binding.Validator.ValidateStruct(&req.Operations[i]); errors.As(err, &validationErrs) {
+            req.Operations[i].Err = &service.ValidationError{Fields: validationFieldErrors(validationErrs)}
+        }
+    }
+
+    result, err := h.service.ApplyBatch(c.Request.Context(), req.Mode, req.Operations)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    for i := range result.Results {
+        item := &result.Results[i]
+        switch {
+        case errors.Is(item.Err, service.ErrBatchAborted):
+            item.Status = http.StatusFailedDependency
+            item.Error = newProblem(c, item.Status, item.Err.Error())
+        case item.Err != nil:
+            item.Error = errorProblem(c, h.logger, item.Err)
+            item.Status = item.Error.Status
+        case item.Op == models.BatchCreate:
+            item.Status = http.StatusCreated
+        default:
+            item.Status = http.StatusOK
+        }
+    }
+
+    h.logger.Infof("Subscription batch applied: %d succeeded, %d failed (mode: %s, committed: %t)",
+        result.Succeeded, result.Failed, result.Mode, result.Committed)
+    c.JSON(http.StatusOK, result)
+}
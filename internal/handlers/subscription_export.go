@@ -0,0 +1,192 @@
+package handlers
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "subscription-service/internal/models"
+)
+
+var subscriptionExport = exportTable{
+    name:  "subscriptions",
+    sheet: "Subscriptions",
+    columns: []exportColumn{
+        {name: "id"},
+        {name: "service_id"},
+        {name: "service_name"},
+        {name: "price", number: true},
+        {name: "currency"},
+        {name: "billing_period"},
+        {name: "billing_anchor_day", number: true},
+        {name: "monthly_equivalent", number: true},
+        {name: "user_id"},
+        {name: "start_date"},
+        {name: "end_date"},
+        {name: "next_billing_date"},
+        {name: "created_at"},
+        {name: "updated_at"},
+        {name: "deleted_at"},
+    },
+}
+
+var summaryExport = exportTable{
+    name:  "summary",
+    sheet: "Summary",
+    columns: []exportColumn{
+        {name: "id"},
+        {name: "service_name"},
+        {name: "user_id"},
+        {name: "price", number: true},
+        {name: "currency"},
+        {name: "billing_period"},
+        {name: "start_date"},
+        {name: "end_date"},
+        {name: "months", number: true},
+        {name: "charges", number: true},
+        {name: "cost", number: true},
+        {name: "amortized_cost", number: true},
+        {name: "cost_currency"},
+    },
+}
+
+var summaryGroupsExport = exportTable{
+    name:  "summary",
+    sheet: "Summary",
+    columns: []exportColumn{
+        {name: "key"},
+        {name: "total_cost", number: true},
+        {name: "subscription_count", number: true},
+        {name: "currency"},
+    },
+}
+
+// exportSubscriptions выгружает все подписки, подходящие под фильтр; limit и cursor не применяются
+func (h *SubscriptionHandler) exportSubscriptions(c *gin.Context, format string, filter *models.SubscriptionFilter, page *models.PageRequest) {
+    h.export(c, format, &subscriptionExport, func(write func(item interface{}, record []string) error) error {
+        return h.service.ExportSubscriptions(c.Request.Context(), filter, page, func(sub *models.Subscription) error {
+            return write(sub, subscriptionRecord(sub))
+        })
+    })
+}
+
+// exportSummary выгружает стоимость каждой подписки за период, а при группировке - строки групп
+func (h *SubscriptionHandler) exportSummary(c *gin.Context, format string, req *models.SummaryRequest) {
+    if req.GroupBy != "" {
+        // Групп немного, и их итоги известны только после обхода всех подписок
+        h.export(c, format, &summaryGroupsExport, func(write func(item interface{}, record []string) error) error {
+            summary, err := h.service.GetSummary(c.Request.Context(), req)
+            if err != nil {
+                return err
+            }
+            for i := range summary.Groups {
+                group := &summary.Groups[i]
+                record := []string{group.Key, group.TotalCost.String(), strconv.Itoa(group.SubscriptionCount), summary.Currency}
+                if err := write(group, record); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        return
+    }
+
+    h.export(c, format, &summaryExport, func(write func(item interface{}, record []string) error) error {
+        return h.service.ExportSummary(c.Request.Context(), req, func(item *models.SubscriptionCost) error {
+            // Без currency суммы остаются в валюте подписки
+            costCurrency := item.Currency
+            if req.Currency != nil {
+                costCurrency = *req.Currency
+            }
+
+            record := []string{
+                item.ID.String(),
+                item.ServiceName,
+                item.UserID.String(),
+                item.Price.String(),
+                item.Currency,
+                string(item.BillingPeriod),
+                formatDate(&item.StartDate),
+                formatDate(item.EndDate),
+                strconv.Itoa(item.Months),
+                strconv.Itoa(item.Charges),
+                item.Cost.String(),
+                item.AmortizedCost.String(),
+                costCurrency,
+            }
+            return write(item, record)
+        })
+    })
+}
+
+// export отправляет выгрузку, которую rows пишет построчно через write. Ошибка до начала ответа
+// возвращается клиенту как обычно; после начала ответ можно только оборвать, и ошибка попадает в журнал.
+func (h *SubscriptionHandler) export(c *gin.Context, format string, table *exportTable, rows func(write func(item interface{}, record []string) error) error) {
+    c.Header("Content-Type", exportContentTypes[format])
+    c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, table.name, format))
+
+    writer, err := newExportWriter(c.Writer, format, table)
+    if err == nil {
+        err = rows(writer.Write)
+    }
+    if err == nil {
+        err = writer.Close()
+    }
+    if err == nil {
+        return
+    }
+
+    if !c.Writer.Written() {
+        c.Writer.Header().Del("Content-Disposition")
+        c.Error(err)
+        return
+    }
+
+    // Без Close архив XLSX остается без оглавления, и клиент увидит поврежденный файл, а не неполный
+    h.logger.Errorf("Export %s.%s failed after the response started: %v", table.name, format, err)
+    c.Abort()
+}
+
+func subscriptionRecord(sub *models.Subscription) []string {
+    serviceID := ""
+    if sub.ServiceID != nil {
+        serviceID = sub.ServiceID.String()
+    }
+    anchorDay := ""
+    if sub.BillingAnchorDay != nil {
+        anchorDay = strconv.Itoa(*sub.BillingAnchorDay)
+    }
+
+    return []string{
+        sub.ID.String(),
+        serviceID,
+        sub.ServiceName,
+        sub.Price.String(),
+        sub.Currency,
+        string(sub.BillingPeriod),
+        anchorDay,
+        sub.MonthlyEquivalent.String(),
+        sub.UserID.String(),
+        formatDate(&sub.StartDate),
+        formatDate(sub.EndDate),
+        formatDate(sub.NextBillingDate),
+        formatTimestamp(&sub.CreatedAt),
+        formatTimestamp(&sub.UpdatedAt),
+        formatTimestamp(sub.DeletedAt),
+    }
+}
+
+func formatDate(t *time.Time) string {
+    if t == nil {
+        return ""
+    }
+    return t.Format("2006-01-02")
+}
+
+func formatTimestamp(t *time.Time) string {
+    if t == nil {
+        return ""
+    }
+    return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/csv"
+    "encoding/xml"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+    "subscription-service/internal/service"
+)
+
+// fakeSubscriptionService отдает подписки subs; err возвращается до первой строки выгрузки.
+// Встроенный интерфейс остается nil, поэтому неожиданный вызов приводит к панике.
+type fakeSubscriptionService struct {
+    service.SubscriptionService
+
+    subs []*models.Subscription
+    err  error
+}
+
+func (s *fakeSubscriptionService) ListSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest) (*models.SubscriptionPage, error) {
+    if s.err != nil {
+        return nil, s.err
+    }
+    return &models.SubscriptionPage{Items: s.subs}, nil
+}
+
+func (s *fakeSubscriptionService) ExportSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest, fn func(sub *models.Subscription) error) error {
+    if s.err != nil {
+        return s.err
+    }
+    for _, sub := range s.subs {
+        if err := fn(sub); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// exportedSubscription - подписка с названием, которое таблица приняла бы за формулу, и символами XML
+func exportedSubscription() *models.Subscription {
+    anchorDay := 15
+    return &models.Subscription{
+        ID:               uuid.New(),
+        ServiceName:      `=HYPERLINK("http://example.com") <Plus & Co>`,
+        Price:            79950,
+        Currency:         "RUB",
+        BillingPeriod:    models.BillingMonthly,
+        BillingAnchorDay: &anchorDay,
+        UserID:           uuid.New(),
+        StartDate:        time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+        CreatedAt:        time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC),
+        UpdatedAt:        time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC),
+    }
+}
+
+func getSubscriptions(svc service.SubscriptionService, query, accept string) *httptest.ResponseRecorder {
+    gin.SetMode(gin.TestMode)
+    logger := newTestLogger()
+
+    router := gin.New()
+    router.Use(ErrorHandler(logger))
+    router.GET("/subscriptions", NewSubscriptionHandler(svc, logger).ListSubscriptions)
+
+    req := httptest.NewRequest(http.MethodGet, "/subscriptions"+query, nil)
+    if accept != "" {
+        req.Header.Set("Accept", accept)
+    }
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w
+}
+
+// xlsxSheet - лист XLSX в объеме, нужном тестам
+type xlsxSheet struct {
+    Rows []struct {
+        Cells []struct {
+            Type   string `xml:"t,attr"`
+            Value  string `xml:"v"`
+            Inline string `xml:"is>t"`
+        } `xml:"c"`
+    } `xml:"sheetData>row"`
+}
+
+func TestExportXLSX(t *testing.T) {
+    sub := exportedSubscription()
+    w := getSubscriptions(&fakeSubscriptionService{subs: []*models.Subscription{sub}}, "?format=xlsx", "")
+    if w.Code != http.StatusOK || w.Header().Get("Content-Type") != mimeXLSX {
+        t.Fatalf("status = %d, content type = %q, want 200 %s", w.Code, w.Header().Get("Content-Type"), mimeXLSX)
+    }
+
+    archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+    if err != nil {
+        t.Fatalf("response is not a zip archive: %v", err)
+    }
+
+    var sheet xlsxSheet
+    for _, f := range archive.File {
+        r, err := f.Open()
+        if err != nil {
+            t.Fatalf("open %s: %v", f.Name, err)
+        }
+        content, err := io.ReadAll(r)
+        r.Close()
+        if err != nil {
+            t.Fatalf("read %s: %v", f.Name, err)
+        }
+
+        // Весь файл должен разбираться как XML, а не только первый элемент
+        decoder := xml.NewDecoder(bytes.NewReader(content))
+        for {
+            if _, err := decoder.Token(); err == io.EOF {
+                break
+            } else if err != nil {
+                t.Fatalf("%s is not valid XML: %v", f.Name, err)
+            }
+        }
+
+        if f.Name == "xl/worksheets/sheet1.xml" {
+            if err := xml.Unmarshal(content, &sheet); err != nil {
+                t.Fatalf("unmarshal sheet: %v", err)
+            }
+        }
+    }
+
+    if len(sheet.Rows) != 2 {
+        t.Fatalf("sheet has %d rows, want header and one subscription", len(sheet.Rows))
+    }
+    header, row := sheet.Rows[0].Cells, sheet.Rows[1].Cells
+    for i, column := range subscriptionExport.columns {
+        if header[i].Inline != column.name {
+            t.Fatalf("header cell %d = %q, want %q", i, header[i].Inline, column.name)
+        }
+    }
+
+    cell := func(name string) int {
+        for i, column := range subscriptionExport.columns {
+            if column.name == name {
+                return i
+            }
+        }
+        t.Fatalf("no column %s", name)
+        return 0
+    }
+    for _, name := range []string{"price", "billing_anchor_day", "monthly_equivalent"} {
+        c := row[cell(name)]
+        if _, err := strconv.ParseFloat(c.Value, 64); c.Type != "" || err != nil {
+            t.Errorf("%s cell = type %q value %q, want a numeric <v>", name, c.Type, c.Value)
+        }
+    }
+    if c := row[cell("price")]; c.Value != "799.50" {
+        t.Errorf("price cell = %q, want 799.50", c.Value)
+    }
+    if c := row[cell("service_name")]; c.Type != "inlineStr" || c.Inline != "'"+sub.ServiceName {
+        t.Errorf("service_name cell = type %q text %q, want inlineStr %q", c.Type, c.Inline, "'"+sub.ServiceName)
+    }
+}
+
+func TestExportCSV(t *testing.T) {
+    sub := exportedSubscription()
+    w := getSubscriptions(&fakeSubscriptionService{subs: []*models.Subscription{sub}}, "?format=csv", "")
+    if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), mimeCSV) {
+        t.Fatalf("status = %d, content type = %q, want 200 %s", w.Code, w.Header().Get("Content-Type"), mimeCSV)
+    }
+    if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="subscriptions.csv"` {
+        t.Fatalf("Content-Disposition = %q", disposition)
+    }
+
+    records, err := csv.NewReader(w.Body).ReadAll()
+    if err != nil {
+        t.Fatalf("response is not valid CSV: %v", err)
+    }
+    if len(records) != 2 {
+        t.Fatalf("CSV has %d records, want header and one subscription", len(records))
+    }
+    if records[0][0] != "\ufeffid" {
+        t.Fatalf("first header cell = %q, want BOM and id", records[0][0])
+    }
+    if records[1][2] != "'"+sub.ServiceName || records[1][3] != "799.50" {
+        t.Fatalf("service_name, price = %q, %q, want %q, 799.50", records[1][2], records[1][3], "'"+sub.ServiceName)
+    }
+}
+
+func TestExportFormatNegotiation(t *testing.T) {
+    tests := []struct {
+        name     string
+        query    string
+        accept   string
+        wantType string
+    }{
+        {name: "any type", accept: "*/*", wantType: "application/json"},
+        {name: "browser", accept: "text/html,application/xhtml+xml,*/*;q=0.8", wantType: "application/json"},
+        {name: "no accept", wantType: "application/json"},
+        {name: "csv accept", accept: "text/csv", wantType: mimeCSV},
+        {name: "ndjson accept", accept: "application/x-ndjson", wantType: mimeNDJSON},
+        {name: "format beats accept", query: "?format=json", accept: "text/csv", wantType: "application/json"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := getSubscriptions(&fakeSubscriptionService{subs: []*models.Subscription{exportedSubscription()}}, tt.query, tt.accept)
+            if w.Code != http.StatusOK {
+                t.Fatalf("status = %d, want 200 (body %s)", w.Code, w.Body)
+            }
+            if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.wantType) {
+                t.Fatalf("Content-Type = %q, want %s", contentType, tt.wantType)
+            }
+        })
+    }
+}
+
+func TestExportErrorBeforeFirstRow(t *testing.T) {
+    for _, format := range []string{formatCSV, formatNDJSON, formatXLSX} {
+        t.Run(format, func(t *testing.T) {
+            w := getSubscriptions(&fakeSubscriptionService{err: errors.New("connection refused")}, "?format="+format, "")
+            if w.Code != http.StatusInternalServerError {
+                t.Fatalf("status = %d, want 500", w.Code)
+            }
+            if contentType := w.Header().Get("Content-Type"); contentType != problemContentType {
+                t.Fatalf("Content-Type = %q, want %s", contentType, problemContentType)
+            }
+            if disposition := w.Header().Get("Content-Disposition"); disposition != "" {
+                t.Fatalf("Content-Disposition = %q, want none", disposition)
+            }
+        })
+    }
+}
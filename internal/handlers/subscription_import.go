@@ -0,0 +1,107 @@
+package handlers
+
+import (
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+    "strconv"
+    "unicode/utf8"
+
+    "github.com/gin-gonic/gin"
+    "subscription-service/internal/models"
+)
+
+// errNoImportFile возвращается, если в multipart/form-data нет поля file
+var errNoImportFile = errors.New("multipart form has no file field")
+
+// ImportSubscriptions загружает подписки из CSV-файла
+// @Summary Загрузить подписки из CSV
+// @Description Принимает CSV с заголовком в поле file формы multipart/form-data или телом запроса (text/csv). По умолчанию колонки называются как поля: service_name, price, user_id, start_date, end_date; другие названия задаются параметрами columns[поле]. Даты - YYYY-MM-DD, DD.MM.YYYY, MM-YYYY, MM.YYYY или RFC 3339, цена - в рублях с точкой или запятой. Каждая строка проходит те же проверки, что и при создании подписки; строки с ошибками пропускаются и перечисляются в ответе. С dry_run=true только проверяет файл, ничего не сохраняя.
+// @Tags subscriptions
+// @Accept mpfd,text/csv
+// @Produce json
+// @Param file formData file false "CSV-файл"
+// @Param dry_run query bool false "Только проверить файл"
+// @Param delimiter query string false "Разделитель колонок (по умолчанию запятая)"
+// @Param columns[service_name] query string false "Колонка с названием сервиса"
+// @Param columns[price] query string false "Колонка с ценой"
+// @Param columns[user_id] query string false "Колонка с ID пользователя"
+// @Param columns[start_date] query string false "Колонка с датой начала"
+// @Param columns[end_date] query string false "Колонка с датой окончания"
+// @Success 200 {object} models.ImportResult
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /imports [post]
+func (h *SubscriptionHandler) ImportSubscriptions(c *gin.Context) {
+    opts := models.ImportOptions{Columns: c.QueryMap("columns")}
+
+    if value := c.Query("dry_run"); value != "" {
+        dryRun, err := strconv.ParseBool(value)
+        if err != nil {
+            respondInvalidParam(c, "dry_run", "must be true or false")
+            return
+        }
+        opts.DryRun = dryRun
+    }
+
+    if value := c.Query("delimiter"); value != "" {
+        delimiter, size := utf8.DecodeRuneInString(value)
+        if size != len(value) || !validDelimiter(delimiter) {
+            respondInvalidParam(c, "delimiter", "must be a single character other than a quote or a line break")
+            return
+        }
+        opts.Delimiter = delimiter
+    }
+
+    file, err := importFile(c)
+    if err != nil {
+        h.logger.Warnf("Invalid import upload: %v", err)
+        respondProblem(c, http.StatusBadRequest, "Request must contain a CSV file in the file field of a multipart form or as the request body")
+        return
+    }
+    defer file.Close()
+
+    result, err := h.service.ImportSubscriptions(c.Request.Context(), file, &opts)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Subscriptions imported: %d of %d rows, %d failed (dry run: %t)",
+        result.Imported, result.Rows, result.Failed, result.DryRun)
+    c.JSON(http.StatusOK, result)
+}
+
+// importFile возвращает содержимое CSV, не читая его в память: поле file формы multipart/form-data
+// или само тело запроса
+func importFile(c *gin.Context) (io.ReadCloser, error) {
+    mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+    if mediaType != "multipart/form-data" {
+        return c.Request.Body, nil
+    }
+
+    reader, err := c.Request.MultipartReader()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            return nil, errNoImportFile
+        }
+        if err != nil {
+            return nil, err
+        }
+        if part.FormName() == "file" {
+            return part, nil
+        }
+        part.Close()
+    }
+}
+
+// validDelimiter повторяет ограничения encoding/csv на разделитель
+func validDelimiter(r rune) bool {
+    return r != '"' && r != '\r' && r != '\n' && r != utf8.RuneError && utf8.ValidRune(r)
+}
@@ -1,16 +1,27 @@
 package handlers
 
 import (
+    "fmt"
     "net/http"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
     "github.com/google/uuid"
     "github.com/sirupsen/logrus"
     "subscription-service/internal/models"
     "subscription-service/internal/service"
 )
 
+const (
+    defaultPageLimit = 50
+    maxPageLimit     = 500
+    maxUpcomingDays  = 366
+)
+
 type SubscriptionHandler struct {
     service service.SubscriptionService
     logger  *logrus.Logger
@@ -25,34 +36,30 @@ func NewSubscriptionHandler(service service.SubscriptionService, logger *logrus.
 
 // CreateSubscription создает новую подписку
 // @Summary Создать подписку
-// @Description Создает новую запись о подписке
+// @Description Создает новую запись о подписке. Сервис задается через service_id или названием, которое сопоставляется с каталогом по синонимам; без цены берется справочная цена из каталога
 // @Tags subscriptions
 // @Accept json
 // @Produce json
 // @Param input body models.CreateSubscriptionRequest true "Данные подписки"
 // @Success 201 {object} models.Subscription
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions [post]
 func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
     var req models.CreateSubscriptionRequest
     if err := c.ShouldBindJSON(&req); err != nil {
         h.logger.Warnf("Invalid request body: %v", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+        respondInvalidBody(c, err)
         return
     }
 
-    subscription := &models.Subscription{
-        ServiceName:  req.ServiceName,
-        Price: req.Price,
-        UserID:       req.UserID,
-        StartDate:    req.StartDate,
-        EndDate:      req.EndDate,
-    }
+    subscription := req.Subscription()
 
-    if err := h.service.CreateSubscription(c.Request.Context(), subscription); err != nil {
-        h.logger.Errorf("Failed to create subscription: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+    err := h.service.CreateSubscription(c.Request.Context(), subscription)
+    if err != nil {
+        c.Error(err)
         return
     }
 
@@ -60,94 +67,243 @@ func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
     c.JSON(http.StatusCreated, subscription)
 }
 
+// UpsertSubscription создает или заменяет подписку по естественному ключу
+// @Summary Создать или заменить подписку по ключу
+// @Description Ищет подписку по пользователю, сервису и дате начала: если ее нет - создает, если есть - заменяет цену, валюту, периодичность и дату окончания. Повтор с теми же данными ничего не меняет. Предназначен для синхронизации с внешними системами.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param input body models.CreateSubscriptionRequest true "Данные подписки"
+// @Success 200 {object} models.Subscription "Подписка уже была и заменена (или не изменилась)"
+// @Success 201 {object} models.Subscription "Подписка создана"
+// @Header 200,201 {string} ETag "Версия подписки"
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/by-key [put]
+func (h *SubscriptionHandler) UpsertSubscription(c *gin.Context) {
+    var req models.CreateSubscriptionRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        h.logger.Warnf("Invalid request body: %v", err)
+        respondInvalidBody(c, err)
+        return
+    }
+
+    subscription := req.Subscription()
+
+    created, err := h.service.UpsertSubscription(c.Request.Context(), subscription)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    status := http.StatusOK
+    if created {
+        status = http.StatusCreated
+    }
+
+    h.logger.Infof("Subscription upserted successfully: %s (created: %t)", subscription.ID, created)
+    c.Header("ETag", etag(subscription.Version))
+    c.JSON(status, subscription)
+}
+
 // GetSubscription получает подписку по ID
 // @Summary Получить подписку
 // @Description Возвращает подписку по её ID
 // @Tags subscriptions
 // @Produce json
 // @Param id path string true "ID подписки"
+// @Param as_of query string false "Момент времени (RFC 3339), на который нужны данные"
 // @Success 200 {object} models.Subscription
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Header 200 {string} ETag "Версия подписки"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions/{id} [get]
 func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
     id, err := uuid.Parse(c.Param("id"))
     if err != nil {
         h.logger.Warnf("Invalid subscription ID: %v", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    asOf, err := parseAsOf(c)
+    if err != nil {
+        h.logger.Warnf("Invalid as_of: %v", err)
+        respondInvalidParam(c, "as_of", "must be an RFC 3339 timestamp")
         return
     }
 
-    subscription, err := h.service.GetSubscription(c.Request.Context(), id)
+    subscription, err := h.service.GetSubscription(c.Request.Context(), id, asOf)
     if err != nil {
-        h.logger.Errorf("Failed to get subscription %s: %v", id, err)
-        c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+        c.Error(err)
         return
     }
 
+    if asOf == nil {
+        c.Header("ETag", etag(subscription.Version))
+    }
     c.JSON(http.StatusOK, subscription)
 }
 
-// UpdateSubscription обновляет подписку
-// @Summary Обновить подписку
-// @Description Обновляет данные подписки
+// UpdateSubscription заменяет подписку
+// @Summary Заменить подписку
+// @Description Полностью заменяет данные подписки, включая пользователя и дату начала; все обязательные поля проверяются заново. Поле price исправляет начальную цену; изменение цены с определенной даты задается через POST /subscriptions/{id}/prices
 // @Tags subscriptions
 // @Accept json
 // @Produce json
 // @Param id path string true "ID подписки"
-// @Param input body models.UpdateSubscriptionRequest true "Данные для обновления"
-// @Success 200 {object} map[string]string
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Param input body models.UpdateSubscriptionRequest true "Новое состояние подписки"
+// @Param If-Match header string false "ETag подписки из GET; при несовпадении версии - 412"
+// @Success 200 {object} models.Subscription
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions/{id} [put]
 func (h *SubscriptionHandler) UpdateSubscription(c *gin.Context) {
     id, err := uuid.Parse(c.Param("id"))
     if err != nil {
         h.logger.Warnf("Invalid subscription ID: %v", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        respondInvalidParam(c, "id", "must be a UUID")
         return
     }
 
     var req models.UpdateSubscriptionRequest
     if err := c.ShouldBindJSON(&req); err != nil {
         h.logger.Warnf("Invalid request body: %v", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+        respondInvalidBody(c, err)
+        return
+    }
+
+    ifMatch, ok := parseIfMatch(c)
+    if !ok {
+        respondProblem(c, http.StatusPreconditionFailed, "If-Match does not match the current version")
+        return
+    }
+
+    subscription, err := h.service.UpdateSubscription(c.Request.Context(), id, &req, ifMatch)
+    h.respondUpdated(c, id, subscription, err)
+}
+
+// PatchSubscription частично изменяет подписку
+// @Summary Изменить подписку
+// @Description Применяет к подписке JSON Merge Patch (RFC 7396): переданные поля заменяются, поле со значением null очищается (например, "end_date": null возобновляет отмененную подписку), остальные не меняются
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path string true "ID подписки"
+// @Param input body object true "JSON Merge Patch с полями UpdateSubscriptionRequest"
+// @Param If-Match header string false "ETag подписки из GET; при несовпадении версии - 412"
+// @Success 200 {object} models.Subscription
+// @Header 200 {string} ETag "Новая версия подписки"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/{id} [patch]
+func (h *SubscriptionHandler) PatchSubscription(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid subscription ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    patch, err := c.GetRawData()
+    if err != nil {
+        h.logger.Warnf("Failed to read request body: %v", err)
+        respondProblem(c, http.StatusBadRequest, "Failed to read request body")
+        return
+    }
+
+    ifMatch, ok := parseIfMatch(c)
+    if !ok {
+        respondProblem(c, http.StatusPreconditionFailed, "If-Match does not match the current version")
+        return
+    }
+
+    // Ошибку наложения патча запоминаем отдельно, чтобы ответить 400/422, а не 500
+    var patchErr error
+    apply := func(req *models.UpdateSubscriptionRequest) error {
+        patchErr = applyMergePatch(req, patch)
+        if patchErr == nil {
+            patchErr = binding.Validator.ValidateStruct(req)
+        }
+        return patchErr
+    }
+
+    subscription, err := h.service.PatchSubscription(c.Request.Context(), id, apply, ifMatch)
+    if patchErr != nil {
+        h.logger.Warnf("Invalid merge patch for subscription %s: %v", id, patchErr)
+        respondInvalidBody(c, patchErr)
         return
     }
+    h.respondUpdated(c, id, subscription, err)
+}
 
-    if err := h.service.UpdateSubscription(c.Request.Context(), id, &req); err != nil {
-        h.logger.Errorf("Failed to update subscription %s: %v", id, err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+// respondUpdated отвечает на PUT и PATCH подписки
+func (h *SubscriptionHandler) respondUpdated(c *gin.Context, id uuid.UUID, subscription *models.Subscription, err error) {
+    if err != nil {
+        c.Error(err)
         return
     }
 
     h.logger.Infof("Subscription updated successfully: %s", id)
-    c.JSON(http.StatusOK, gin.H{"message": "Subscription updated successfully"})
+    c.Header("ETag", etag(subscription.Version))
+    c.JSON(http.StatusOK, subscription)
 }
 
 // DeleteSubscription удаляет подписку
 // @Summary Удалить подписку
-// @Description Удаляет подписку по её ID
+// @Description Помечает подписку удаленной: она пропадает из списка и отчетов, но ее можно восстановить до очистки
 // @Tags subscriptions
 // @Produce json
 // @Param id path string true "ID подписки"
+// @Param deleted_at query string false "Момент удаления (RFC 3339), по умолчанию текущий; будущий не принимается"
+// @Param If-Match header string false "ETag подписки из GET; при несовпадении версии - 412"
 // @Success 200 {object} map[string]string
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions/{id} [delete]
 func (h *SubscriptionHandler) DeleteSubscription(c *gin.Context) {
     id, err := uuid.Parse(c.Param("id"))
     if err != nil {
         h.logger.Warnf("Invalid subscription ID: %v", err)
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    ifMatch, ok := parseIfMatch(c)
+    if !ok {
+        respondProblem(c, http.StatusPreconditionFailed, "If-Match does not match the current version")
         return
     }
 
-    if err := h.service.DeleteSubscription(c.Request.Context(), id); err != nil {
-        h.logger.Errorf("Failed to delete subscription %s: %v", id, err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete subscription"})
+    var deletedAt *time.Time
+    if value := c.Query("deleted_at"); value != "" {
+        at, err := time.Parse(time.RFC3339Nano, value)
+        if err != nil {
+            h.logger.Warnf("Invalid deleted_at: %v", err)
+            respondInvalidParam(c, "deleted_at", "must be an RFC 3339 timestamp")
+            return
+        }
+        deletedAt = &at
+    }
+
+    err = h.service.DeleteSubscription(c.Request.Context(), id, deletedAt, ifMatch)
+    if err != nil {
+        c.Error(err)
         return
     }
 
@@ -155,34 +311,215 @@ func (h *SubscriptionHandler) DeleteSubscription(c *gin.Context) {
     c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted successfully"})
 }
 
+// RestoreSubscription восстанавливает удаленную подписку
+// @Summary Восстановить подписку
+// @Description Снимает с подписки отметку об удалении
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "ID подписки"
+// @Success 200 {object} models.Subscription
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/{id}/restore [post]
+func (h *SubscriptionHandler) RestoreSubscription(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid subscription ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    subscription, err := h.service.RestoreSubscription(c.Request.Context(), id)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Subscription restored successfully: %s", id)
+    c.Header("ETag", etag(subscription.Version))
+    c.JSON(http.StatusOK, subscription)
+}
+
+// SchedulePriceChange планирует изменение цены подписки
+// @Summary Изменить цену с даты
+// @Description Задает новую цену подписки, действующую с указанной даты до следующего изменения. Месяцы до этой даты в сводке считаются по прежней цене. Дата не может быть в прошлом.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path string true "ID подписки"
+// @Param input body models.PriceChange true "Новая цена и дата начала ее действия"
+// @Success 201 {object} models.Subscription
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/{id}/prices [post]
+func (h *SubscriptionHandler) SchedulePriceChange(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid subscription ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    var change models.PriceChange
+    if err := c.ShouldBindJSON(&change); err != nil {
+        h.logger.Warnf("Invalid request body: %v", err)
+        respondInvalidBody(c, err)
+        return
+    }
+
+    subscription, err := h.service.SchedulePriceChange(c.Request.Context(), id, &change)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Price change scheduled for subscription %s", id)
+    c.Header("ETag", etag(subscription.Version))
+    c.JSON(http.StatusCreated, subscription)
+}
+
+// GetHistory возвращает журнал изменений подписки
+// @Summary История изменений подписки
+// @Description Возвращает все изменения подписки в хронологическом порядке: снимки до и после, инициатора и ID запроса. История доступна и для удаленных подписок.
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "ID подписки"
+// @Success 200 {array} models.SubscriptionEvent
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/{id}/history [get]
+func (h *SubscriptionHandler) GetHistory(c *gin.Context) {
+    id, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        h.logger.Warnf("Invalid subscription ID: %v", err)
+        respondInvalidParam(c, "id", "must be a UUID")
+        return
+    }
+
+    events, err := h.service.GetHistory(c.Request.Context(), id)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, events)
+}
+
+// PurgeDeletedSubscriptions окончательно удаляет давно удаленные подписки
+// @Summary Очистить удаленные подписки
+// @Description Безвозвратно удаляет подписки, помеченные удаленными больше older_than_days дней назад. Запросы с as_of на моменты до очистки по-прежнему их видят
+// @Tags admin
+// @Produce json
+// @Param older_than_days query int true "Сколько дней подписка должна пролежать удаленной"
+// @Success 200 {object} map[string]int64
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /admin/subscriptions/purge [post]
+func (h *SubscriptionHandler) PurgeDeletedSubscriptions(c *gin.Context) {
+    days, err := strconv.Atoi(c.Query("older_than_days"))
+    if err != nil || days < 0 {
+        h.logger.Warnf("Invalid older_than_days: %q", c.Query("older_than_days"))
+        respondInvalidParam(c, "older_than_days", "must be a non-negative integer")
+        return
+    }
+
+    purged, err := h.service.PurgeDeleted(c.Request.Context(), days)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    h.logger.Infof("Purged %d deleted subscriptions", purged)
+    c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
 // ListSubscriptions возвращает список подписок
 // @Summary Список подписок
-// @Description Возвращает список подписок с возможностью фильтрации
+// @Description Возвращает список подписок с возможностью фильтрации. С format=csv, ndjson или xlsx (или заголовком Accept с соответствующим типом) выгружает файл со всеми подписками, подходящими под фильтр: limit и cursor к выгрузке не применяются
 // @Tags subscriptions
-// @Produce json
+// @Produce json,text/csv,application/x-ndjson,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param user_id query string false "ID пользователя"
-// @Param service_name query string false "Название сервиса"
-// @Success 200 {array} models.Subscription
-// @Failure 500 {object} map[string]string
+// @Param service_name query []string false "Название сервиса (можно указать несколько)" collectionFormat(multi)
+// @Param q query string false "Нечеткий поиск по названию сервиса"
+// @Param active_on query string false "Подписка активна на дату (YYYY-MM-DD)"
+// @Param started_after query string false "Подписка начата после даты (YYYY-MM-DD)"
+// @Param started_before query string false "Подписка начата до даты (YYYY-MM-DD)"
+// @Param ended query bool false "true - только завершенные подписки, false - только действующие"
+// @Param min_price query number false "Минимальная цена"
+// @Param max_price query number false "Максимальная цена"
+// @Param include_deleted query bool false "Включить удаленные подписки"
+// @Param as_of query string false "Момент времени (RFC 3339), на который нужны данные"
+// @Param limit query int false "Размер страницы" default(50)
+// @Param cursor query string false "Курсор следующей страницы (next_cursor из предыдущего ответа)"
+// @Param sort query string false "Поле сортировки: created_at, price, start_date, service_name или relevance (при q - по умолчанию)" default(created_at)
+// @Param order query string false "Направление сортировки: asc или desc" default(desc)
+// @Param format query string false "Формат ответа: json, csv, ndjson или xlsx; по умолчанию выбирается по заголовку Accept"
+// @Success 200 {object} models.SubscriptionPage
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions [get]
 func (h *SubscriptionHandler) ListSubscriptions(c *gin.Context) {
-    var userID *uuid.UUID
-    var serviceName *string
+    var filter models.SubscriptionFilter
+    if !h.bindListFilter(c, &filter) {
+        return
+    }
 
-    if userIDStr := c.Query("user_id"); userIDStr != "" {
-        if id, err := uuid.Parse(userIDStr); err == nil {
-            userID = &id
+    defaultSort := models.SortCreatedAt
+    if filter.Query != "" {
+        defaultSort = models.SortRelevance
+    }
+
+    page := models.PageRequest{
+        Cursor: c.Query("cursor"),
+        Sort:   c.DefaultQuery("sort", defaultSort),
+        Order:  c.DefaultQuery("order", models.OrderDesc),
+    }
+
+    limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+    if err != nil || limit < 1 || limit > maxPageLimit {
+        h.logger.Warnf("Invalid limit: %s", c.Query("limit"))
+        respondInvalidParam(c, "limit", fmt.Sprintf("must be an integer between 1 and %d", maxPageLimit))
+        return
+    }
+    page.Limit = limit
+
+    switch page.Sort {
+    case models.SortCreatedAt, models.SortPrice, models.SortStartDate, models.SortServiceName:
+    case models.SortRelevance:
+        if filter.Query == "" {
+            respondInvalidParam(c, "sort", "relevance requires q")
+            return
         }
+    default:
+        h.logger.Warnf("Invalid sort: %s", page.Sort)
+        respondInvalidParam(c, "sort", "must be one of: created_at, price, start_date, service_name, relevance")
+        return
     }
 
-    if serviceNameStr := c.Query("service_name"); serviceNameStr != "" {
-        serviceName = &serviceNameStr
+    if page.Order != models.OrderAsc && page.Order != models.OrderDesc {
+        h.logger.Warnf("Invalid order: %s", page.Order)
+        respondInvalidParam(c, "order", "must be one of: asc, desc")
+        return
+    }
+
+    format, ok := exportFormat(c)
+    if !ok {
+        return
+    }
+    if format != formatJSON {
+        h.exportSubscriptions(c, format, &filter, &page)
+        return
     }
 
-    subscriptions, err := h.service.ListSubscriptions(c.Request.Context(), userID, serviceName)
+    subscriptions, err := h.service.ListSubscriptions(c.Request.Context(), &filter, &page)
     if err != nil {
-        h.logger.Errorf("Failed to list subscriptions: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list subscriptions"})
+        c.Error(err)
         return
     }
 
@@ -191,26 +528,256 @@ func (h *SubscriptionHandler) ListSubscriptions(c *gin.Context) {
 
 // GetSummary возвращает суммарную стоимость подписок за период
 // @Summary Сумма подписок
-// @Description Возвращает суммарную стоимость подписок за указанный период
+// @Description Возвращает суммарную стоимость списаний по подпискам за указанный период с учетом периодичности оплаты, амортизированную помесячную стоимость и разбивку по подпискам. С format=csv, ndjson или xlsx (или заголовком Accept с соответствующим типом) выгружает стоимость каждой подписки, а при group_by - строки групп
 // @Tags subscriptions
-// @Produce json
+// @Produce json,text/csv,application/x-ndjson,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param start_date query string true "Начальная дата (YYYY-MM-DD)"
 // @Param end_date query string true "Конечная дата (YYYY-MM-DD)"
 // @Param user_id query string false "ID пользователя"
 // @Param service_name query string false "Название сервиса"
+// @Param group_by query string false "Группировка: service_name или user_id"
+// @Param currency query string false "Валюта итогов (ISO 4217); без нее суммы не пересчитываются"
+// @Param as_of query string false "Момент времени (RFC 3339), на который нужны данные"
+// @Param format query string false "Формат ответа: json, csv, ndjson или xlsx; по умолчанию выбирается по заголовку Accept"
 // @Success 200 {object} models.SubscriptionSummary
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /subscriptions/summary [get]
 func (h *SubscriptionHandler) GetSummary(c *gin.Context) {
-    var req models.SummaryRequest
+    req := models.SummaryRequest{GroupBy: c.Query("group_by")}
+    if !h.bindSummaryRequest(c, &req) {
+        return
+    }
+
+    switch req.GroupBy {
+    case "", models.GroupByServiceName, models.GroupByUserID:
+    default:
+        h.logger.Warnf("Invalid group_by: %s", req.GroupBy)
+        respondInvalidParam(c, "group_by", "must be one of: service_name, user_id")
+        return
+    }
+
+    format, ok := exportFormat(c)
+    if !ok {
+        return
+    }
+    if format != formatJSON {
+        h.exportSummary(c, format, &req)
+        return
+    }
+
+    summary, err := h.service.GetSummary(c.Request.Context(), &req)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, summary)
+}
+
+// GetTimeSeries возвращает стоимость подписок с разбивкой по периодам
+// @Summary Динамика расходов
+// @Description Возвращает стоимость подписок по месяцам, кварталам или годам с числом активных подписок и суммами по сервисам
+// @Tags subscriptions
+// @Produce json
+// @Param start_date query string true "Начальная дата (YYYY-MM-DD)"
+// @Param end_date query string true "Конечная дата (YYYY-MM-DD)"
+// @Param granularity query string false "Шаг разбивки: month, quarter или year" default(month)
+// @Param user_id query string false "ID пользователя"
+// @Param service_name query string false "Название сервиса"
+// @Param currency query string false "Валюта итогов (ISO 4217); без нее суммы не пересчитываются"
+// @Param as_of query string false "Момент времени (RFC 3339), на который нужны данные"
+// @Success 200 {object} models.TimeSeries
+// @Failure 400 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/summary/timeseries [get]
+func (h *SubscriptionHandler) GetTimeSeries(c *gin.Context) {
+    req := models.TimeSeriesRequest{Granularity: c.DefaultQuery("granularity", models.GranularityMonth)}
+    if !h.bindSummaryRequest(c, &req.SummaryRequest) {
+        return
+    }
+
+    switch req.Granularity {
+    case models.GranularityMonth, models.GranularityQuarter, models.GranularityYear:
+    default:
+        h.logger.Warnf("Invalid granularity: %s", req.Granularity)
+        respondInvalidParam(c, "granularity", "must be one of: month, quarter, year")
+        return
+    }
+
+    if req.StartDate == nil {
+        respondInvalidParam(c, "start_date", "is required")
+        return
+    }
+    if req.EndDate == nil {
+        respondInvalidParam(c, "end_date", "is required")
+        return
+    }
+
+    if req.EndDate.Before(*req.StartDate) {
+        respondInvalidParam(c, "end_date", "must not be before start_date")
+        return
+    }
+
+    series, err := h.service.GetTimeSeries(c.Request.Context(), &req)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, series)
+}
+
+// ListUpcomingCharges возвращает ожидаемые списания
+// @Summary Предстоящие списания
+// @Description Возвращает все списания по подпискам, ожидаемые в ближайшие дни, с суммами
+// @Tags subscriptions
+// @Produce json
+// @Param days query int false "Число дней, начиная с сегодняшнего включительно" default(30)
+// @Param user_id query string false "ID пользователя"
+// @Param service_name query string false "Название сервиса"
+// @Success 200 {object} models.UpcomingCharges
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /subscriptions/upcoming [get]
+func (h *SubscriptionHandler) ListUpcomingCharges(c *gin.Context) {
+    days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+    if err != nil || days < 1 || days > maxUpcomingDays {
+        h.logger.Warnf("Invalid days: %s", c.Query("days"))
+        respondInvalidParam(c, "days", fmt.Sprintf("must be an integer between 1 and %d", maxUpcomingDays))
+        return
+    }
+
+    req := models.UpcomingRequest{Days: days}
+
+    if userIDStr := c.Query("user_id"); userIDStr != "" {
+        id, err := uuid.Parse(userIDStr)
+        if err != nil {
+            h.logger.Warnf("Invalid user_id: %v", err)
+            respondInvalidParam(c, "user_id", "must be a UUID")
+            return
+        }
+        req.UserID = &id
+    }
 
+    if serviceNameStr := c.Query("service_name"); serviceNameStr != "" {
+        req.ServiceName = &serviceNameStr
+    }
+
+    charges, err := h.service.ListUpcomingCharges(c.Request.Context(), &req)
+    if err != nil {
+        c.Error(err)
+        return
+    }
+
+    c.JSON(http.StatusOK, charges)
+}
+
+// bindListFilter разбирает фильтры списка подписок; при ошибке ответ уже отправлен
+func (h *SubscriptionHandler) bindListFilter(c *gin.Context, filter *models.SubscriptionFilter) bool {
+    badRequest := func(param string, err error) bool {
+        h.logger.Warnf("Invalid %s: %v", param, err)
+        respondInvalidParam(c, param, err.Error())
+        return false
+    }
+
+    if userIDStr := c.Query("user_id"); userIDStr != "" {
+        id, err := uuid.Parse(userIDStr)
+        if err != nil {
+            return badRequest("user_id", err)
+        }
+        filter.UserID = &id
+    }
+
+    for _, name := range c.QueryArray("service_name") {
+        if name = strings.TrimSpace(name); name != "" {
+            filter.ServiceNames = append(filter.ServiceNames, name)
+        }
+    }
+
+    filter.Query = strings.TrimSpace(c.Query("q"))
+
+    dates := []struct {
+        param string
+        dst   **time.Time
+    }{
+        {"active_on", &filter.ActiveOn},
+        {"started_after", &filter.StartedAfter},
+        {"started_before", &filter.StartedBefore},
+    }
+    for _, d := range dates {
+        if value := c.Query(d.param); value != "" {
+            date, err := time.Parse("2006-01-02", value)
+            if err != nil {
+                return badRequest(d.param, err)
+            }
+            *d.dst = &date
+        }
+    }
+
+    if endedStr := c.Query("ended"); endedStr != "" {
+        ended, err := strconv.ParseBool(endedStr)
+        if err != nil {
+            return badRequest("ended", err)
+        }
+        filter.Ended = &ended
+    }
+
+    if includeDeletedStr := c.Query("include_deleted"); includeDeletedStr != "" {
+        includeDeleted, err := strconv.ParseBool(includeDeletedStr)
+        if err != nil {
+            return badRequest("include_deleted", err)
+        }
+        filter.IncludeDeleted = includeDeleted
+    }
+
+    asOf, err := parseAsOf(c)
+    if err != nil {
+        return badRequest("as_of", err)
+    }
+    filter.AsOf = asOf
+
+    prices := []struct {
+        param string
+        dst   **models.Money
+    }{
+        {"min_price", &filter.MinPrice},
+        {"max_price", &filter.MaxPrice},
+    }
+    for _, p := range prices {
+        if value := c.Query(p.param); value != "" {
+            price, err := models.ParseMoney(value)
+            if err != nil {
+                return badRequest(p.param, err)
+            }
+            if price < 0 {
+                return badRequest(p.param, fmt.Errorf("negative price %s", price))
+            }
+            *p.dst = &price
+        }
+    }
+
+    if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+        return badRequest("price range", fmt.Errorf("min_price is greater than max_price"))
+    }
+
+    if filter.StartedAfter != nil && filter.StartedBefore != nil && !filter.StartedAfter.Before(*filter.StartedBefore) {
+        return badRequest("start date range", fmt.Errorf("started_after is not before started_before"))
+    }
+
+    return true
+}
+
+// bindSummaryRequest разбирает общие параметры отчетов о стоимости; при ошибке ответ уже отправлен
+func (h *SubscriptionHandler) bindSummaryRequest(c *gin.Context, req *models.SummaryRequest) bool {
     if startDateStr := c.Query("start_date"); startDateStr != "" {
         startDate, err := time.Parse("2006-01-02", startDateStr)
         if err != nil {
             h.logger.Warnf("Invalid start_date: %v", err)
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date format. Use YYYY-MM-DD"})
-            return
+            respondInvalidParam(c, "start_date", "must be a date in YYYY-MM-DD format")
+            return false
         }
         req.StartDate = &startDate
     }
@@ -219,28 +786,86 @@ func (h *SubscriptionHandler) GetSummary(c *gin.Context) {
         endDate, err := time.Parse("2006-01-02", endDateStr)
         if err != nil {
             h.logger.Warnf("Invalid end_date: %v", err)
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date format. Use YYYY-MM-DD"})
-            return
+            respondInvalidParam(c, "end_date", "must be a date in YYYY-MM-DD format")
+            return false
         }
         req.EndDate = &endDate
     }
 
     if userIDStr := c.Query("user_id"); userIDStr != "" {
-        if id, err := uuid.Parse(userIDStr); err == nil {
-            req.UserID = &id
+        id, err := uuid.Parse(userIDStr)
+        if err != nil {
+            h.logger.Warnf("Invalid user_id: %v", err)
+            respondInvalidParam(c, "user_id", "must be a UUID")
+            return false
         }
+        req.UserID = &id
     }
 
     if serviceNameStr := c.Query("service_name"); serviceNameStr != "" {
         req.ServiceName = &serviceNameStr
     }
 
-    summary, err := h.service.GetSummary(c.Request.Context(), &req)
+    if currencyStr := c.Query("currency"); currencyStr != "" {
+        currencyStr = strings.ToUpper(currencyStr)
+        if err := binding.Validator.Engine().(*validator.Validate).Var(currencyStr, "iso4217"); err != nil {
+            h.logger.Warnf("Invalid currency: %s", currencyStr)
+            respondInvalidParam(c, "currency", "must be an ISO 4217 currency code")
+            return false
+        }
+        req.Currency = &currencyStr
+    }
+
+    asOf, err := parseAsOf(c)
     if err != nil {
-        h.logger.Errorf("Failed to get summary: %v", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate summary"})
-        return
+        h.logger.Warnf("Invalid as_of: %v", err)
+        respondInvalidParam(c, "as_of", "must be an RFC 3339 timestamp")
+        return false
     }
+    req.AsOf = asOf
 
-    c.JSON(http.StatusOK, summary)
+    return true
+}
+
+// etag возвращает ETag подписки по номеру ее версии
+func etag(version int) string {
+    return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseIfMatch разбирает заголовок If-Match в список допустимых версий.
+// Без заголовка и для "*" возвращает nil - версия не проверяется. ok = false означает,
+// что ни один из ETag не может совпасть (например, все слабые), и запрос нужно отклонить с 412.
+func parseIfMatch(c *gin.Context) (versions []int64, ok bool) {
+    header := strings.TrimSpace(c.GetHeader("If-Match"))
+    if header == "" || header == "*" {
+        return nil, true
+    }
+
+    for _, tag := range strings.Split(header, ",") {
+        tag = strings.TrimSpace(tag)
+        // If-Match использует строгое сравнение, слабые ETag не совпадают никогда
+        if strings.HasPrefix(tag, "W/") {
+            continue
+        }
+        version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 32)
+        if err != nil {
+            continue
+        }
+        versions = append(versions, version)
+    }
+    return versions, len(versions) > 0
+}
+
+// parseAsOf разбирает параметр as_of - момент, на который нужно вернуть данные
+func parseAsOf(c *gin.Context) (*time.Time, error) {
+    asOfStr := c.Query("as_of")
+    if asOfStr == "" {
+        return nil, nil
+    }
+
+    asOf, err := time.Parse(time.RFC3339Nano, asOfStr)
+    if err != nil {
+        return nil, err
+    }
+    return &asOf, nil
 }
\ No newline at end of file
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+)
+
+func (s *fakeSubscriptionService) GetSummary(ctx context.Context, req *models.SummaryRequest) (*models.SubscriptionSummary, error) {
+    return &models.SubscriptionSummary{}, nil
+}
+
+func (s *fakeSubscriptionService) GetTimeSeries(ctx context.Context, req *models.TimeSeriesRequest) (*models.TimeSeries, error) {
+    return &models.TimeSeries{}, nil
+}
+
+func TestSummaryUserID(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    logger := newTestLogger()
+    handler := NewSubscriptionHandler(&fakeSubscriptionService{}, logger)
+
+    router := gin.New()
+    router.Use(ErrorHandler(logger))
+    router.GET("/summary", handler.GetSummary)
+    router.GET("/summary/timeseries", handler.GetTimeSeries)
+
+    tests := []struct {
+        name       string
+        userID     string
+        wantStatus int
+    }{
+        {name: "valid", userID: uuid.New().String(), wantStatus: http.StatusOK},
+        {name: "not a uuid", userID: "garbage", wantStatus: http.StatusBadRequest},
+    }
+
+    for _, path := range []string{"/summary", "/summary/timeseries"} {
+        for _, tt := range tests {
+            t.Run(path+" "+tt.name, func(t *testing.T) {
+                req := httptest.NewRequest(http.MethodGet, path+"?start_date=2024-01-01&end_date=2024-12-31&user_id="+tt.userID, nil)
+                w := httptest.NewRecorder()
+                router.ServeHTTP(w, req)
+
+                if w.Code != tt.wantStatus {
+                    t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body)
+                }
+                if tt.wantStatus != http.StatusBadRequest {
+                    return
+                }
+
+                var problem models.Problem
+                if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+                    t.Fatalf("response is not a problem: %v", err)
+                }
+                if len(problem.Errors) != 1 || problem.Errors[0].Field != "user_id" {
+                    t.Fatalf("problem errors = %+v, want user_id", problem.Errors)
+                }
+            })
+        }
+    }
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "subscription-service/internal/requestctx"
+)
+
+const (
+    RequestIDHeader = "X-Request-ID"
+    ActorHeader     = "X-Actor"
+)
+
+// maxHeaderValueLen ограничивает длину значений, которые попадают в журнал изменений
+const maxHeaderValueLen = 100
+
+// RequestContext кладет в контекст запроса его ID и инициатора изменений.
+// ID берется из заголовка X-Request-ID или генерируется и возвращается в ответе;
+// инициатор передается шлюзом авторизации в заголовке X-Actor.
+func RequestContext() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        requestID := headerValue(c, RequestIDHeader)
+        if requestID == "" {
+            requestID = uuid.New().String()
+        }
+        c.Header(RequestIDHeader, requestID)
+
+        ctx := requestctx.WithRequestID(c.Request.Context(), requestID)
+        if actor := headerValue(c, ActorHeader); actor != "" {
+            ctx = requestctx.WithActor(ctx, actor)
+        }
+        c.Request = c.Request.WithContext(ctx)
+
+        c.Next()
+    }
+}
+
+func headerValue(c *gin.Context, name string) string {
+    value := strings.TrimSpace(c.GetHeader(name))
+    if len(value) > maxHeaderValueLen {
+        value = value[:maxHeaderValueLen]
+    }
+    return value
+}
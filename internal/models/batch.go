@@ -0,0 +1,65 @@
+package models
+
+import "github.com/google/uuid"
+
+// BatchMode - режим выполнения пакета операций
+type BatchMode string
+
+const (
+    // BatchAtomic - все или ничего: ошибка любой операции откатывает весь пакет
+    BatchAtomic BatchMode = "atomic"
+    // BatchBestEffort - успешные операции сохраняются, даже если часть операций не удалась
+    BatchBestEffort BatchMode = "best_effort"
+)
+
+// BatchOp - вид операции в пакете
+type BatchOp string
+
+const (
+    BatchCreate BatchOp = "create"
+    BatchUpdate BatchOp = "update"
+    BatchDelete BatchOp = "delete"
+)
+
+// BatchRequest - тело POST /subscriptions:batch. Операции проверяются по отдельности,
+// поэтому ошибка в одной из них не отклоняет весь запрос.
+type BatchRequest struct {
+    Mode       BatchMode        `json:"mode,omitempty" binding:"omitempty,oneof=atomic best_effort"`
+    Operations []BatchOperation `json:"operations" binding:"required,min=1"`
+}
+
+// BatchOperation - одна операция пакета. Create задает подписку для create, Update - полное состояние для update;
+// ID и необязательный IfMatch (ожидаемая версия) нужны для update и delete.
+type BatchOperation struct {
+    Op      BatchOp                    `json:"op" binding:"required,oneof=create update delete"`
+    ID      *uuid.UUID                 `json:"id,omitempty" binding:"required_unless=Op create"`
+    IfMatch *int                       `json:"if_match,omitempty" binding:"omitempty,min=1"`
+    Create  *CreateSubscriptionRequest `json:"create,omitempty" binding:"required_if=Op create"`
+    Update  *UpdateSubscriptionRequest `json:"update,omitempty" binding:"required_if=Op update"`
+
+    // Err - ошибка проверки операции, найденная до выполнения пакета
+    Err error `json:"-"`
+}
+
+// BatchItemResult - итог одной операции. Status - код HTTP, который получила бы операция,
+// выполненная отдельным запросом; 424 означает, что операция откатилась из-за ошибки другой.
+type BatchItemResult struct {
+    Index        int           `json:"index"`
+    Op           BatchOp       `json:"op"`
+    Status       int           `json:"status"`
+    ID           *uuid.UUID    `json:"id,omitempty"`
+    Subscription *Subscription `json:"subscription,omitempty"`
+    Error        *Problem      `json:"error,omitempty"`
+
+    Err error `json:"-"`
+}
+
+// BatchResult - ответ на пакет операций. Committed - были ли сохранены изменения;
+// Succeeded и Failed считают сохраненные и несохраненные операции.
+type BatchResult struct {
+    Mode      BatchMode         `json:"mode"`
+    Committed bool              `json:"committed"`
+    Succeeded int               `json:"succeeded"`
+    Failed    int               `json:"failed"`
+    Results   []BatchItemResult `json:"results"`
+}
@@ -0,0 +1,139 @@
+package models
+
+import "time"
+
+// BillingPeriod - периодичность списаний по подписке
+type BillingPeriod string
+
+const (
+    BillingWeekly    BillingPeriod = "weekly"
+    BillingMonthly   BillingPeriod = "monthly"
+    BillingQuarterly BillingPeriod = "quarterly"
+    BillingYearly    BillingPeriod = "yearly"
+)
+
+func (p BillingPeriod) Valid() bool {
+    switch p {
+    case BillingWeekly, BillingMonthly, BillingQuarterly, BillingYearly:
+        return true
+    }
+    return false
+}
+
+// MaxAnchorDay возвращает максимально допустимый день привязки списаний:
+// день недели (1 - понедельник, 7 - воскресенье) для еженедельных подписок и день месяца для остальных
+func (p BillingPeriod) MaxAnchorDay() int {
+    if p == BillingWeekly {
+        return 7
+    }
+    return 31
+}
+
+// MonthlyEquivalent возвращает стоимость подписки, равномерно распределенную на один месяц
+func (p BillingPeriod) MonthlyEquivalent(price Money) Money {
+    switch p {
+    case BillingWeekly:
+        return price.MulDiv(52, 12)
+    case BillingQuarterly:
+        return price.MulDiv(1, 3)
+    case BillingYearly:
+        return price.MulDiv(1, 12)
+    default:
+        return price
+    }
+}
+
+func (p BillingPeriod) months() int {
+    switch p {
+    case BillingQuarterly:
+        return 3
+    case BillingYearly:
+        return 12
+    default:
+        return 1
+    }
+}
+
+// ChargeDates возвращает даты списаний по подписке внутри [from, to] включительно.
+// Первое списание происходит в день начала подписки, следующие - в день привязки каждого следующего периода.
+func (s *Subscription) ChargeDates(from, to time.Time) []time.Time {
+    var dates []time.Time
+    for k := 0; ; k++ {
+        date := s.chargeDate(k)
+        if date.After(to) || (s.EndDate != nil && date.After(*s.EndDate)) {
+            break
+        }
+        if !date.Before(from) {
+            dates = append(dates, date)
+        }
+    }
+    return dates
+}
+
+// NextChargeDate возвращает ближайшую дату списания не раньше on или nil, если списаний больше не будет
+func (s *Subscription) NextChargeDate(on time.Time) *time.Time {
+    for k := 0; ; k++ {
+        date := s.chargeDate(k)
+        if s.EndDate != nil && date.After(*s.EndDate) {
+            return nil
+        }
+        if !date.Before(on) {
+            return &date
+        }
+    }
+}
+
+// PriceChange - новая цена подписки, действующая с даты EffectiveFrom до следующего изменения
+type PriceChange struct {
+    EffectiveFrom time.Time `json:"effective_from" binding:"required"`
+    Price         Money     `json:"price" binding:"required,gt=0"`
+    CreatedAt     time.Time `json:"created_at"`
+}
+
+// PriceOn возвращает цену подписки, действующую на дату date. PriceChanges должны быть упорядочены по дате.
+func (s *Subscription) PriceOn(date time.Time) Money {
+    price := s.Price
+    for _, change := range s.PriceChanges {
+        if change.EffectiveFrom.After(date) {
+            break
+        }
+        price = change.Price
+    }
+    return price
+}
+
+// FillDerived заполняет вычисляемые поля подписки на дату now
+func (s *Subscription) FillDerived(now time.Time) {
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    s.MonthlyEquivalent = s.BillingPeriod.MonthlyEquivalent(s.PriceOn(today))
+    s.NextBillingDate = s.NextChargeDate(today)
+}
+
+// chargeDate возвращает дату k-го списания. Дата считается от начала подписки, а не от предыдущего
+// списания, поэтому привязка к 31 числу дает 29 февраля и снова 31 марта.
+func (s *Subscription) chargeDate(k int) time.Time {
+    start := time.Date(s.StartDate.Year(), s.StartDate.Month(), s.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+    if k == 0 {
+        return start
+    }
+
+    if s.BillingPeriod == BillingWeekly {
+        date := start.AddDate(0, 0, 7*k)
+        if s.BillingAnchorDay != nil {
+            anchor := time.Weekday(*s.BillingAnchorDay % 7)
+            date = date.AddDate(0, 0, (int(anchor)-int(date.Weekday())+7)%7)
+        }
+        return date
+    }
+
+    anchor := start.Day()
+    if s.BillingAnchorDay != nil {
+        anchor = *s.BillingAnchorDay
+    }
+
+    month := time.Date(start.Year(), start.Month()+time.Month(k*s.BillingPeriod.months()), 1, 0, 0, 0, 0, time.UTC)
+    if last := month.AddDate(0, 1, -1).Day(); anchor > last {
+        anchor = last
+    }
+    return time.Date(month.Year(), month.Month(), anchor, 0, 0, 0, 0, time.UTC)
+}
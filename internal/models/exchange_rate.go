@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// ExchangeRate - курс, по которому 1 единица FromCurrency переводится в ToCurrency начиная с EffectiveDate
+type ExchangeRate struct {
+    FromCurrency  string    `json:"from_currency" binding:"required,iso4217"`
+    ToCurrency    string    `json:"to_currency" binding:"required,iso4217"`
+    EffectiveDate time.Time `json:"effective_date" binding:"required"`
+    Rate          Rate      `json:"rate"`
+}
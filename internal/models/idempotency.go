@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// IdempotencyRecord - сохраненный результат запроса с заголовком Idempotency-Key.
+// Ключ действует в пределах Scope - метода и пути запроса. StatusCode равен nil,
+// пока первый запрос с этим ключом еще выполняется.
+type IdempotencyRecord struct {
+    Scope       string
+    Key         string
+    RequestHash string
+    StatusCode  *int
+    ContentType string
+    Body        []byte
+    CreatedAt   time.Time
+    ExpiresAt   time.Time
+}
@@ -0,0 +1,35 @@
+package models
+
+// ImportFields - поля подписки, которые загружаются из CSV, в порядке колонок файла по умолчанию
+var ImportFields = []string{"service_name", "price", "user_id", "start_date", "end_date"}
+
+// ImportOptions - параметры загрузки подписок из CSV
+type ImportOptions struct {
+    // Columns сопоставляет поле подписки из ImportFields с названием колонки в заголовке файла.
+    // Если поле не указано, колонка называется так же, как поле.
+    Columns map[string]string
+    // Delimiter - разделитель колонок; 0 означает запятую
+    Delimiter rune
+    // DryRun - только проверить строки, ничего не сохраняя
+    DryRun bool
+}
+
+// ImportRowError - ошибки одной строки файла. Row - номер строки в файле, считая заголовок;
+// поля в Errors названы колонками файла.
+type ImportRowError struct {
+    Row     int          `json:"row"`
+    Message string       `json:"message"`
+    Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// ImportResult - итог загрузки. При dry_run Imported - число строк, которые были бы загружены.
+// Errors содержит не все ошибки, если их слишком много (ErrorsTruncated); остальные учтены в Failed.
+type ImportResult struct {
+    DryRun          bool             `json:"dry_run"`
+    Rows            int              `json:"rows"`
+    Imported        int              `json:"imported"`
+    Failed          int              `json:"failed"`
+    Errors          []ImportRowError `json:"errors"`
+    ErrorsTruncated bool             `json:"errors_truncated,omitempty"`
+}
+
@@ -0,0 +1,241 @@
+package models
+
+import (
+    "database/sql/driver"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+)
+
+// Money - денежная сумма в минимальных единицах валюты (копейках, центах).
+// Хранится как целое число, чтобы суммы не накапливали ошибку округления.
+type Money int64
+
+const moneyScale = 100
+
+// maxMoneyDigits ограничивает целую часть суммы, чтобы умножение на число месяцев не переполняло int64
+const maxMoneyDigits = 15
+
+// ParseMoney разбирает десятичную запись суммы. Допускается не больше двух знаков после точки.
+func ParseMoney(s string) (Money, error) {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return 0, fmt.Errorf("empty amount")
+    }
+
+    negative := false
+    if s[0] == '-' || s[0] == '+' {
+        negative = s[0] == '-'
+        s = s[1:]
+    }
+
+    whole, frac, hasDot := strings.Cut(s, ".")
+    if whole == "" || (hasDot && frac == "") {
+        return 0, fmt.Errorf("invalid amount %q", s)
+    }
+    if len(frac) > 2 {
+        return 0, fmt.Errorf("amount %q has more than two fractional digits", s)
+    }
+    if len(whole) > maxMoneyDigits {
+        return 0, fmt.Errorf("amount %q is too large", s)
+    }
+    if !isDigits(whole) || !isDigits(frac) {
+        return 0, fmt.Errorf("invalid amount %q", s)
+    }
+
+    units, _ := strconv.ParseInt(whole, 10, 64)
+    cents := int64(0)
+    if frac != "" {
+        cents, _ = strconv.ParseInt(frac+strings.Repeat("0", 2-len(frac)), 10, 64)
+    }
+
+    amount := units*moneyScale + cents
+    if negative {
+        amount = -amount
+    }
+    return Money(amount), nil
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// Mul возвращает сумму, умноженную на целое число (например, на число оплаченных месяцев)
+func (m Money) Mul(n int) Money {
+    return m * Money(n)
+}
+
+// MulDiv возвращает m*num/den с округлением до минимальной единицы (половина округляется от нуля)
+func (m Money) MulDiv(num, den int64) Money {
+    return m.mulRat(big.NewRat(num, den))
+}
+
+// Convert переводит сумму в другую валюту по курсу с округлением до минимальной единицы
+func (m Money) Convert(rate Rate) Money {
+    if rate.r == nil {
+        return m
+    }
+    return m.mulRat(rate.r)
+}
+
+func (m Money) mulRat(r *big.Rat) Money {
+    num := new(big.Int).Mul(big.NewInt(int64(m)), r.Num())
+    den := r.Denom()
+    quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+
+    rem.Abs(rem).Lsh(rem, 1)
+    if rem.Cmp(den) >= 0 {
+        if num.Sign() < 0 {
+            quo.Sub(quo, big.NewInt(1))
+        } else {
+            quo.Add(quo, big.NewInt(1))
+        }
+    }
+    return Money(quo.Int64())
+}
+
+func (m Money) String() string {
+    sign := ""
+    v := int64(m)
+    if v < 0 {
+        sign = "-"
+        v = -v
+    }
+    return fmt.Sprintf("%s%d.%02d", sign, v/moneyScale, v%moneyScale)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+    return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает как число (399.90), так и строку ("399.90")
+func (m *Money) UnmarshalJSON(data []byte) error {
+    s := strings.Trim(string(data), `"`)
+    if s == "null" {
+        return nil
+    }
+
+    v, err := ParseMoney(s)
+    if err != nil {
+        return err
+    }
+    *m = v
+    return nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+    switch v := src.(type) {
+    case []byte:
+        return m.scanString(string(v))
+    case string:
+        return m.scanString(v)
+    case int64:
+        *m = Money(v * moneyScale)
+        return nil
+    default:
+        return fmt.Errorf("cannot scan %T into Money", src)
+    }
+}
+
+func (m *Money) scanString(s string) error {
+    // NUMERIC(10,2) может прийти с лишними нулями, например "399.000"
+    if whole, frac, ok := strings.Cut(s, "."); ok && len(frac) > 2 {
+        s = whole + "." + strings.TrimRight(frac, "0")
+        s = strings.TrimSuffix(s, ".")
+    }
+
+    v, err := ParseMoney(s)
+    if err != nil {
+        return err
+    }
+    *m = v
+    return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+    return m.String(), nil
+}
+
+// Rate - курс обмена валют. Хранится как точная дробь, чтобы пересчет не зависел от float64.
+type Rate struct {
+    r *big.Rat
+}
+
+// ParseRate разбирает положительный десятичный курс, например "92.5"
+func ParseRate(s string) (Rate, error) {
+    r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+    if !ok {
+        return Rate{}, fmt.Errorf("invalid rate %q", s)
+    }
+    if r.Sign() <= 0 {
+        return Rate{}, fmt.Errorf("rate must be positive")
+    }
+    return Rate{r: r}, nil
+}
+
+func (r Rate) IsZero() bool {
+    return r.r == nil
+}
+
+// Inverse возвращает обратный курс
+func (r Rate) Inverse() Rate {
+    if r.r == nil {
+        return r
+    }
+    return Rate{r: new(big.Rat).Inv(r.r)}
+}
+
+func (r Rate) String() string {
+    if r.r == nil {
+        return "0"
+    }
+    s := strings.TrimRight(r.r.FloatString(8), "0")
+    return strings.TrimSuffix(s, ".")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+    return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+    s := strings.Trim(string(data), `"`)
+    if s == "null" {
+        return nil
+    }
+
+    v, err := ParseRate(s)
+    if err != nil {
+        return err
+    }
+    *r = v
+    return nil
+}
+
+func (r *Rate) Scan(src interface{}) error {
+    var s string
+    switch v := src.(type) {
+    case []byte:
+        s = string(v)
+    case string:
+        s = v
+    default:
+        return fmt.Errorf("cannot scan %T into Rate", src)
+    }
+
+    v, err := ParseRate(s)
+    if err != nil {
+        return err
+    }
+    *r = v
+    return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+    return r.String(), nil
+}
@@ -0,0 +1,20 @@
+package models
+
+// Problem - описание ошибки в формате RFC 7807 (application/problem+json)
+type Problem struct {
+    Type      string       `json:"type"`
+    Title     string       `json:"title"`
+    Status    int          `json:"status"`
+    Detail    string       `json:"detail,omitempty"`
+    Instance  string       `json:"instance,omitempty"`
+    RequestID string       `json:"request_id,omitempty"`
+    Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError - ошибка в конкретном поле запроса. Field - имя поля в JSON или параметра запроса,
+// Rule - нарушенное правило проверки (required, gt, iso4217 и т.п.).
+type FieldError struct {
+    Field   string `json:"field"`
+    Rule    string `json:"rule,omitempty"`
+    Message string `json:"message"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Service - запись каталога сервисов: каноническое название, синонимы и справочные цены
+type Service struct {
+    ID            uuid.UUID     `json:"id" db:"id"`
+    Name          string        `json:"name" db:"name"`
+    Aliases       []string      `json:"aliases" db:"aliases"`
+    Category      *string       `json:"category,omitempty" db:"category"`
+    VendorURL     *string       `json:"vendor_url,omitempty" db:"vendor_url"`
+    DefaultPrices DefaultPrices `json:"default_prices" db:"default_prices"`
+    Currency      string        `json:"currency" db:"currency"`
+    CreatedAt     time.Time     `json:"created_at" db:"created_at"`
+    UpdatedAt     time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+type ServiceRequest struct {
+    Name          string        `json:"name" binding:"required,max=255"`
+    Aliases       []string      `json:"aliases,omitempty" binding:"omitempty,dive,required,max=255"`
+    Category      *string       `json:"category,omitempty" binding:"omitempty,max=100"`
+    VendorURL     *string       `json:"vendor_url,omitempty" binding:"omitempty,url"`
+    DefaultPrices DefaultPrices `json:"default_prices,omitempty"`
+    Currency      string        `json:"currency,omitempty" binding:"omitempty,iso4217"`
+}
+
+type ServiceFilter struct {
+    Query    string
+    Category *string
+}
+
+// DefaultPrices - справочная цена сервиса для каждой периодичности оплаты
+type DefaultPrices map[BillingPeriod]Money
+
+// Validate проверяет, что все периоды известны, а цены положительны
+func (p DefaultPrices) Validate() error {
+    for period, price := range p {
+        if !period.Valid() {
+            return fmt.Errorf("unknown billing period %q", period)
+        }
+        if price <= 0 {
+            return fmt.Errorf("default price for %s must be positive", period)
+        }
+    }
+    return nil
+}
+
+func (p *DefaultPrices) Scan(src interface{}) error {
+    var data []byte
+    switch v := src.(type) {
+    case []byte:
+        data = v
+    case string:
+        data = []byte(v)
+    default:
+        return fmt.Errorf("cannot scan %T into DefaultPrices", src)
+    }
+
+    prices := DefaultPrices{}
+    if err := json.Unmarshal(data, &prices); err != nil {
+        return err
+    }
+    *p = prices
+    return nil
+}
+
+func (p DefaultPrices) Value() (driver.Value, error) {
+    if p == nil {
+        return "{}", nil
+    }
+    data, err := json.Marshal(p)
+    if err != nil {
+        return nil, err
+    }
+    return string(data), nil
+}
@@ -1,44 +1,238 @@
 package models
 
 import (
+    "strings"
     "time"
 
     "github.com/google/uuid"
 )
 
+// DefaultCurrency - валюта подписок, созданных без явного указания валюты
+const DefaultCurrency = "RUB"
+
+// NormalizeServiceName приводит название сервиса к каноническому виду для сравнения и поиска:
+// нижний регистр, без пробелов по краям и с одиночными пробелами между словами.
+// Должна совпадать с выражением колонки service_name_normalized.
+func NormalizeServiceName(name string) string {
+    return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
 type Subscription struct {
-    ID           uuid.UUID `json:"id" db:"id"`
-    ServiceName  string    `json:"service_name" db:"service_name"`
-    Price float64   `json:"price" db:"price"`
-    UserID       uuid.UUID `json:"user_id" db:"user_id"`
-    StartDate    time.Time `json:"start_date" db:"start_date"`
-    EndDate      *time.Time `json:"end_date,omitempty" db:"end_date"`
-    CreatedAt    time.Time `json:"created_at" db:"created_at"`
-    UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+    ID                uuid.UUID     `json:"id" db:"id"`
+    ServiceID         *uuid.UUID    `json:"service_id,omitempty" db:"service_id"`
+    ServiceName       string        `json:"service_name" db:"service_name"`
+    Price             Money         `json:"price" db:"price"`
+    PriceChanges      []PriceChange `json:"price_changes,omitempty" db:"-"`
+    Currency          string        `json:"currency" db:"currency"`
+    BillingPeriod     BillingPeriod `json:"billing_period" db:"billing_period"`
+    BillingAnchorDay  *int          `json:"billing_anchor_day,omitempty" db:"billing_anchor_day"`
+    MonthlyEquivalent Money         `json:"monthly_equivalent" db:"-"`
+    NextBillingDate   *time.Time    `json:"next_billing_date,omitempty" db:"-"`
+    UserID            uuid.UUID     `json:"user_id" db:"user_id"`
+    StartDate         time.Time     `json:"start_date" db:"start_date"`
+    EndDate           *time.Time    `json:"end_date,omitempty" db:"end_date"`
+    CreatedAt         time.Time     `json:"created_at" db:"created_at"`
+    UpdatedAt         time.Time     `json:"updated_at" db:"updated_at"`
+    DeletedAt         *time.Time    `json:"deleted_at,omitempty" db:"deleted_at"`
+    Version           int           `json:"version" db:"version"`
+}
+
+// NaturalKey возвращает естественный ключ подписки - пользователь, нормализованное название сервиса
+// и дата начала, - по которому среди действующих подписок не бывает дубликатов
+func (s *Subscription) NaturalKey() string {
+    return s.UserID.String() + "|" + NormalizeServiceName(s.ServiceName) + "|" + s.StartDate.UTC().Format("2006-01-02")
 }
 
 type CreateSubscriptionRequest struct {
-    ServiceName  string    `json:"service_name" binding:"required"`
-    Price float64   `json:"price" binding:"required,gt=0"`
-    UserID       uuid.UUID `json:"user_id" binding:"required"`
-    StartDate    time.Time `json:"start_date" binding:"required"`
-    EndDate      *time.Time `json:"end_date,omitempty"`
+    ServiceID        *uuid.UUID    `json:"service_id,omitempty"`
+    ServiceName      string        `json:"service_name,omitempty" binding:"required_without=ServiceID"`
+    Price            Money         `json:"price,omitempty" binding:"omitempty,gt=0"`
+    Currency         string        `json:"currency,omitempty" binding:"omitempty,iso4217"`
+    BillingPeriod    BillingPeriod `json:"billing_period,omitempty" binding:"omitempty,oneof=weekly monthly quarterly yearly"`
+    BillingAnchorDay *int          `json:"billing_anchor_day,omitempty" binding:"omitempty,min=1,max=31"`
+    UserID           uuid.UUID     `json:"user_id" binding:"required"`
+    StartDate        time.Time     `json:"start_date" binding:"required"`
+    EndDate          *time.Time    `json:"end_date,omitempty"`
 }
 
+// Subscription возвращает новую подписку из запроса; периодичность по умолчанию - ежемесячная
+func (r *CreateSubscriptionRequest) Subscription() *Subscription {
+    billingPeriod := r.BillingPeriod
+    if billingPeriod == "" {
+        billingPeriod = BillingMonthly
+    }
+
+    return &Subscription{
+        ServiceID:        r.ServiceID,
+        ServiceName:      r.ServiceName,
+        Price:            r.Price,
+        Currency:         r.Currency,
+        BillingPeriod:    billingPeriod,
+        BillingAnchorDay: r.BillingAnchorDay,
+        UserID:           r.UserID,
+        StartDate:        r.StartDate,
+        EndDate:          r.EndDate,
+    }
+}
+
+// UpdateSubscriptionRequest - полное состояние подписки для PUT. PATCH накладывается на это же
+// представление текущей подписки как JSON Merge Patch, поэтому отсутствующее поле означает пустое значение.
 type UpdateSubscriptionRequest struct {
-    ServiceName  *string    `json:"service_name,omitempty"`
-    Price *float64   `json:"price,omitempty"`
-    EndDate      *time.Time `json:"end_date,omitempty"`
+    ServiceID        *uuid.UUID    `json:"service_id,omitempty"`
+    ServiceName      string        `json:"service_name,omitempty" binding:"required_without=ServiceID"`
+    Price            Money         `json:"price" binding:"required,gt=0"`
+    Currency         string        `json:"currency" binding:"required,iso4217"`
+    BillingPeriod    BillingPeriod `json:"billing_period" binding:"required,oneof=weekly monthly quarterly yearly"`
+    BillingAnchorDay *int          `json:"billing_anchor_day,omitempty" binding:"omitempty,min=1,max=31"`
+    UserID           uuid.UUID     `json:"user_id" binding:"required"`
+    StartDate        time.Time     `json:"start_date" binding:"required"`
+    EndDate          *time.Time    `json:"end_date,omitempty"`
+}
+
+// UpdateRequest возвращает текущее состояние подписки в виде запроса на полное обновление
+func (s *Subscription) UpdateRequest() UpdateSubscriptionRequest {
+    return UpdateSubscriptionRequest{
+        ServiceID:        s.ServiceID,
+        ServiceName:      s.ServiceName,
+        Price:            s.Price,
+        Currency:         s.Currency,
+        BillingPeriod:    s.BillingPeriod,
+        BillingAnchorDay: s.BillingAnchorDay,
+        UserID:           s.UserID,
+        StartDate:        s.StartDate,
+        EndDate:          s.EndDate,
+    }
 }
 
 type SubscriptionSummary struct {
-    TotalCost   float64 `json:"total_cost"`
-    Subscriptions []Subscription `json:"subscriptions,omitempty"`
+    TotalCost     Money              `json:"total_cost"`
+    AmortizedCost Money              `json:"amortized_cost"`
+    Currency      string             `json:"currency,omitempty"`
+    Subscriptions []SubscriptionCost `json:"subscriptions,omitempty"`
+    Groups        []SummaryGroup     `json:"groups,omitempty"`
+}
+
+// SubscriptionCost - вклад одной подписки в сумму за период: фактические списания
+// и стоимость, равномерно распределенная по активным месяцам
+type SubscriptionCost struct {
+    Subscription
+    Months        int   `json:"months"`
+    Charges       int   `json:"charges"`
+    Cost          Money `json:"cost"`
+    AmortizedCost Money `json:"amortized_cost"`
+}
+
+// SummaryGroup - строка сводки, сгруппированной по сервису или пользователю
+type SummaryGroup struct {
+    Key               string `json:"key"`
+    TotalCost         Money  `json:"total_cost"`
+    SubscriptionCount int    `json:"subscription_count"`
 }
 
+const (
+    GroupByServiceName = "service_name"
+    GroupByUserID      = "user_id"
+)
+
 type SummaryRequest struct {
     StartDate   *time.Time `form:"start_date,omitempty"`
     EndDate     *time.Time `form:"end_date,omitempty"`
     UserID     *uuid.UUID `form:"user_id,omitempty"`
     ServiceName *string    `form:"service_name,omitempty"`
-}
\ No newline at end of file
+    Currency    *string    `form:"currency,omitempty"`
+    GroupBy     string     `form:"group_by,omitempty"`
+    AsOf        *time.Time `form:"as_of,omitempty"`
+}
+
+const (
+    GranularityMonth   = "month"
+    GranularityQuarter = "quarter"
+    GranularityYear    = "year"
+)
+
+type TimeSeriesRequest struct {
+    SummaryRequest
+    Granularity string `form:"granularity,omitempty"`
+}
+
+type TimeSeries struct {
+    Granularity string             `json:"granularity"`
+    Currency    string             `json:"currency,omitempty"`
+    Buckets     []TimeSeriesBucket `json:"buckets"`
+}
+
+type TimeSeriesBucket struct {
+    PeriodStart         time.Time     `json:"period_start"`
+    PeriodEnd           time.Time     `json:"period_end"`
+    TotalCost           Money         `json:"total_cost"`
+    AmortizedCost       Money         `json:"amortized_cost"`
+    ActiveSubscriptions int           `json:"active_subscriptions"`
+    Services            []ServiceCost `json:"services"`
+}
+
+type ServiceCost struct {
+    ServiceName string `json:"service_name"`
+    TotalCost   Money  `json:"total_cost"`
+}
+
+type UpcomingRequest struct {
+    Days        int        `form:"days,omitempty"`
+    UserID      *uuid.UUID `form:"user_id,omitempty"`
+    ServiceName *string    `form:"service_name,omitempty"`
+}
+
+// UpcomingCharge - ожидаемое списание по подписке
+type UpcomingCharge struct {
+    SubscriptionID uuid.UUID `json:"subscription_id"`
+    ServiceName    string    `json:"service_name"`
+    UserID         uuid.UUID `json:"user_id"`
+    Date           time.Time `json:"date"`
+    Amount         Money     `json:"amount"`
+    Currency       string    `json:"currency"`
+}
+
+type UpcomingCharges struct {
+    From    time.Time        `json:"from"`
+    To      time.Time        `json:"to"`
+    Charges []UpcomingCharge `json:"charges"`
+}
+
+// SubscriptionFilter - условия отбора подписок в списке; пустые поля не ограничивают выборку
+type SubscriptionFilter struct {
+    UserID         *uuid.UUID
+    ServiceNames   []string
+    Query          string
+    ActiveOn       *time.Time
+    StartedAfter   *time.Time
+    StartedBefore  *time.Time
+    Ended          *bool
+    MinPrice       *Money
+    MaxPrice       *Money
+    IncludeDeleted bool
+    AsOf           *time.Time
+}
+
+const (
+    SortCreatedAt   = "created_at"
+    SortPrice       = "price"
+    SortStartDate   = "start_date"
+    SortServiceName = "service_name"
+    SortRelevance   = "relevance"
+
+    OrderAsc  = "asc"
+    OrderDesc = "desc"
+)
+
+// PageRequest - параметры постраничной выдачи. Cursor - непрозрачная строка из next_cursor предыдущей страницы.
+type PageRequest struct {
+    Limit  int    `form:"limit,omitempty"`
+    Cursor string `form:"cursor,omitempty"`
+    Sort   string `form:"sort,omitempty"`
+    Order  string `form:"order,omitempty"`
+}
+
+type SubscriptionPage struct {
+    Items      []*Subscription `json:"items"`
+    NextCursor string          `json:"next_cursor,omitempty"`
+    HasMore    bool            `json:"has_more"`
+}
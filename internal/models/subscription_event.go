@@ -0,0 +1,30 @@
+package models
+
+import (
+    "encoding/json"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Типы событий журнала изменений подписки
+const (
+    EventCreated  = "create"
+    EventUpdated  = "update"
+    EventDeleted  = "delete"
+    EventRestored = "restore"
+    EventPurged   = "purge"
+    EventRepriced = "price_change"
+)
+
+// SubscriptionEvent - запись журнала изменений подписки: снимки строки до и после изменения
+type SubscriptionEvent struct {
+    ID             int64           `json:"id" db:"id"`
+    SubscriptionID uuid.UUID       `json:"subscription_id" db:"subscription_id"`
+    Type           string          `json:"event_type" db:"event_type"`
+    Before         json.RawMessage `json:"before,omitempty" db:"before" swaggertype:"object"`
+    After          json.RawMessage `json:"after,omitempty" db:"after" swaggertype:"object"`
+    Actor          *string         `json:"actor,omitempty" db:"actor"`
+    RequestID      *string         `json:"request_id,omitempty" db:"request_id"`
+    CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+}
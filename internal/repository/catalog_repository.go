@@ -0,0 +1,358 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+
+    "github.com/google/uuid"
+    "github.com/lib/pq"
+    "subscription-service/internal/models"
+    "subscription-service/internal/requestctx"
+)
+
+var (
+    ErrServiceNotFound  = fmt.Errorf("service %w", ErrNotFound)
+    ErrServiceNameTaken = fmt.Errorf("%w: service name or alias is already used by another service", ErrConflict)
+)
+
+// CatalogRepository хранит каталог сервисов (таблица services)
+type CatalogRepository interface {
+    Create(ctx context.Context, svc *models.Service) error
+    GetByID(ctx context.Context, id uuid.UUID) (*models.Service, error)
+    Update(ctx context.Context, svc *models.Service) error
+    Delete(ctx context.Context, id uuid.UUID) error
+    List(ctx context.Context, filter *models.ServiceFilter) ([]*models.Service, error)
+    Resolve(ctx context.Context, name string) (*models.Service, error)
+}
+
+const serviceColumns = "id, name, aliases, category, vendor_url, default_prices, currency, created_at, updated_at"
+
+func scanService(row rowScanner) (*models.Service, error) {
+    var svc models.Service
+    err := row.Scan(
+        &svc.ID,
+        &svc.Name,
+        pq.Array(&svc.Aliases),
+        &svc.Category,
+        &svc.VendorURL,
+        &svc.DefaultPrices,
+        &svc.Currency,
+        &svc.CreatedAt,
+        &svc.UpdatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+    return &svc, nil
+}
+
+type catalogRepo struct {
+    db *sql.DB
+}
+
+func NewCatalogRepository(db *sql.DB) CatalogRepository {
+    return &catalogRepo{db: db}
+}
+
+func (r *catalogRepo) Create(ctx context.Context, svc *models.Service) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := r.checkNamesFree(ctx, tx, uuid.Nil, svc); err != nil {
+        return err
+    }
+
+    query := `
+        INSERT INTO services (name, aliases, aliases_normalized, category, vendor_url, default_prices, currency)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at, updated_at
+    `
+
+    err = tx.QueryRowContext(
+        ctx,
+        query,
+        svc.Name,
+        pq.Array(svc.Aliases),
+        pq.Array(normalizedAliases(svc)),
+        svc.Category,
+        svc.VendorURL,
+        svc.DefaultPrices,
+        svc.Currency,
+    ).Scan(&svc.ID, &svc.CreatedAt, &svc.UpdatedAt)
+    if err != nil {
+        if isDuplicateError(err) {
+            return ErrServiceNameTaken
+        }
+        log.Printf("Error creating service: %v", err)
+        return fmt.Errorf("failed to create service: %w", err)
+    }
+
+    if err := linkSubscriptions(ctx, tx, svc); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit service: %w", err)
+    }
+
+    log.Printf("Created service with ID: %s", svc.ID)
+    return nil
+}
+
+func (r *catalogRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Service, error) {
+    query := `SELECT ` + serviceColumns + ` FROM services WHERE id = $1`
+
+    svc, err := scanService(r.db.QueryRowContext(ctx, query, id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrServiceNotFound
+        }
+        log.Printf("Error getting service by ID %s: %v", id, err)
+        return nil, fmt.Errorf("failed to get service: %w", err)
+    }
+
+    return svc, nil
+}
+
+func (r *catalogRepo) Update(ctx context.Context, svc *models.Service) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := r.checkNamesFree(ctx, tx, svc.ID, svc); err != nil {
+        return err
+    }
+
+    query := `
+        UPDATE services
+        SET name = $1,
+            aliases = $2,
+            aliases_normalized = $3,
+            category = $4,
+            vendor_url = $5,
+            default_prices = $6,
+            currency = $7,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $8
+        RETURNING created_at, updated_at
+    `
+
+    err = tx.QueryRowContext(
+        ctx,
+        query,
+        svc.Name,
+        pq.Array(svc.Aliases),
+        pq.Array(normalizedAliases(svc)),
+        svc.Category,
+        svc.VendorURL,
+        svc.DefaultPrices,
+        svc.Currency,
+        svc.ID,
+    ).Scan(&svc.CreatedAt, &svc.UpdatedAt)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return ErrServiceNotFound
+        }
+        if isDuplicateError(err) {
+            return ErrServiceNameTaken
+        }
+        log.Printf("Error updating service %s: %v", svc.ID, err)
+        return fmt.Errorf("failed to update service: %w", err)
+    }
+
+    if err := linkSubscriptions(ctx, tx, svc); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit service: %w", err)
+    }
+
+    log.Printf("Updated service with ID: %s", svc.ID)
+    return nil
+}
+
+// Delete удаляет сервис из каталога. Подписки сервиса сначала отвязываются явно, с новой версией и событием
+// журнала: ON DELETE SET NULL внешнего ключа изменил бы их незаметно для истории.
+func (r *catalogRepo) Delete(ctx context.Context, id uuid.UUID) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    unlinked, err := setSubscriptionsService(ctx, tx, nil, "service_id = $2", id)
+    if err != nil {
+        log.Printf("Error unlinking subscriptions from service %s: %v", id, err)
+        return fmt.Errorf("failed to unlink subscriptions from service: %w", err)
+    }
+
+    result, err := tx.ExecContext(ctx, `DELETE FROM services WHERE id = $1`, id)
+    if err != nil {
+        log.Printf("Error deleting service %s: %v", id, err)
+        return fmt.Errorf("failed to delete service: %w", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    if rows == 0 {
+        return ErrServiceNotFound
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit service deletion: %w", err)
+    }
+
+    log.Printf("Deleted service with ID: %s, unlinked %d subscriptions", id, unlinked)
+    return nil
+}
+
+func (r *catalogRepo) List(ctx context.Context, filter *models.ServiceFilter) ([]*models.Service, error) {
+    query := `SELECT ` + serviceColumns + ` FROM services WHERE 1=1`
+    args := []interface{}{}
+    argPos := 1
+
+    if filter.Query != "" {
+        q := "%" + likeEscaper.Replace(models.NormalizeServiceName(filter.Query)) + "%"
+        query += fmt.Sprintf(" AND (name_normalized LIKE $%d OR array_to_string(aliases_normalized, ' ') LIKE $%d)", argPos, argPos)
+        args = append(args, q)
+        argPos++
+    }
+
+    if filter.Category != nil {
+        query += fmt.Sprintf(" AND category = $%d", argPos)
+        args = append(args, *filter.Category)
+    }
+
+    query += " ORDER BY name"
+
+    rows, err := r.db.QueryContext(ctx, query, args...)
+    if err != nil {
+        log.Printf("Error listing services: %v", err)
+        return nil, fmt.Errorf("failed to list services: %w", err)
+    }
+    defer rows.Close()
+
+    services := []*models.Service{}
+    for rows.Next() {
+        svc, err := scanService(rows)
+        if err != nil {
+            return nil, fmt.Errorf("failed to scan service: %w", err)
+        }
+        services = append(services, svc)
+    }
+
+    return services, rows.Err()
+}
+
+// Resolve ищет сервис каталога по каноническому названию или синониму
+func (r *catalogRepo) Resolve(ctx context.Context, name string) (*models.Service, error) {
+    query := `
+        SELECT ` + serviceColumns + `
+        FROM services
+        WHERE name_normalized = $1 OR $1 = ANY(aliases_normalized)
+        ORDER BY (name_normalized = $1) DESC
+        LIMIT 1
+    `
+
+    svc, err := scanService(r.db.QueryRowContext(ctx, query, models.NormalizeServiceName(name)))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrServiceNotFound
+        }
+        return nil, fmt.Errorf("failed to resolve service: %w", err)
+    }
+
+    return svc, nil
+}
+
+// checkNamesFree проверяет, что название и синонимы не заняты другим сервисом каталога
+func (r *catalogRepo) checkNamesFree(ctx context.Context, tx *sql.Tx, id uuid.UUID, svc *models.Service) error {
+    names := append(normalizedAliases(svc), models.NormalizeServiceName(svc.Name))
+
+    query := `
+        SELECT EXISTS (
+            SELECT 1 FROM services
+            WHERE id <> $1 AND (name_normalized = ANY($2) OR aliases_normalized && $2)
+        )
+    `
+
+    var taken bool
+    if err := tx.QueryRowContext(ctx, query, id, pq.Array(names)).Scan(&taken); err != nil {
+        return fmt.Errorf("failed to check service names: %w", err)
+    }
+    if taken {
+        return ErrServiceNameTaken
+    }
+    return nil
+}
+
+// linkSubscriptions привязывает к сервису подписки, записанные свободным текстом с его названием или синонимом
+func linkSubscriptions(ctx context.Context, tx *sql.Tx, svc *models.Service) error {
+    names := append(normalizedAliases(svc), models.NormalizeServiceName(svc.Name))
+
+    rows, err := setSubscriptionsService(ctx, tx, svc.ID, "service_id IS NULL AND service_name_normalized = ANY($2)", pq.Array(names))
+    if err != nil {
+        return fmt.Errorf("failed to link subscriptions to service: %w", err)
+    }
+
+    if rows > 0 {
+        log.Printf("Linked %d subscriptions to service %s", rows, svc.ID)
+    }
+    return nil
+}
+
+// setSubscriptionsService записывает serviceID (nil - отвязать) в подписки, выбранные условием where с аргументом $2.
+// Это обычное изменение подписки: версия растет, а событие журнала со снимками до и после пишется тем же запросом.
+func setSubscriptionsService(ctx context.Context, tx *sql.Tx, serviceID interface{}, where string, arg interface{}) (int64, error) {
+    query := `
+        WITH target AS (
+            SELECT id, ` + snapshotColumn + ` AS before
+            FROM subscriptions
+            WHERE ` + where + `
+            FOR UPDATE
+        ), changed AS (
+            UPDATE subscriptions
+            SET service_id = $1,
+                version = version + 1,
+                updated_at = CURRENT_TIMESTAMP
+            FROM target
+            WHERE subscriptions.id = target.id
+            RETURNING subscriptions.id, target.before, ` + snapshotColumn + ` AS after
+        )
+        INSERT INTO subscription_events (subscription_id, event_type, before, after, actor, request_id)
+        SELECT id, $3, before, after, $4, $5 FROM changed
+    `
+
+    result, err := tx.ExecContext(
+        ctx,
+        query,
+        serviceID,
+        arg,
+        models.EventUpdated,
+        nullString(requestctx.Actor(ctx)),
+        nullString(requestctx.RequestID(ctx)),
+    )
+    if err != nil {
+        return 0, err
+    }
+    return result.RowsAffected()
+}
+
+func normalizedAliases(svc *models.Service) []string {
+    aliases := make([]string, 0, len(svc.Aliases))
+    for _, alias := range svc.Aliases {
+        aliases = append(aliases, models.NormalizeServiceName(alias))
+    }
+    return aliases
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+    "context"
+    "database/sql/driver"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+)
+
+func TestCatalogChangesRecordSubscriptionEvents(t *testing.T) {
+    // Привязка и отвязка подписок - один запрос, который пишет и изменение, и событие журнала
+    relink := fakeStep{query: "INSERT INTO subscription_events (subscription_id, event_type, before, after, actor, request_id)"}
+
+    tests := []struct {
+        name    string
+        steps   []fakeStep
+        call    func(repo CatalogRepository) error
+    }{
+        {
+            name: "create links subscriptions",
+            steps: []fakeStep{
+                {query: "SELECT EXISTS", columns: []string{"exists"}, rows: [][]driver.Value{{false}}},
+                {query: "INSERT INTO services", columns: []string{"id", "created_at", "updated_at"}, rows: [][]driver.Value{{uuid.New().String(), time.Now(), time.Now()}}},
+                relink,
+            },
+            call: func(repo CatalogRepository) error {
+                return repo.Create(context.Background(), &models.Service{Name: "Netflix", Currency: "RUB"})
+            },
+        },
+        {
+            name:  "delete unlinks subscriptions first",
+            steps: []fakeStep{relink, {query: "DELETE FROM services"}},
+            call: func(repo CatalogRepository) error {
+                return repo.Delete(context.Background(), uuid.New())
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db, fake := newFakeDB(t, tt.steps...)
+            if err := tt.call(NewCatalogRepository(db)); err != nil {
+                t.Fatalf("error = %v, want nil", err)
+            }
+            if fake.log[0] != "BEGIN" || fake.log[len(fake.log)-1] != "COMMIT" {
+                t.Fatalf("statements = %q, want one transaction", fake.log)
+            }
+        })
+    }
+}
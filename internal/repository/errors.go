@@ -0,0 +1,31 @@
+package repository
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/lib/pq"
+)
+
+// Базовые ошибки репозиториев. Конкретные ошибки оборачивают одну из них,
+// поэтому вызывающий код может проверять как errors.Is(err, ErrSubscriptionNotFound),
+// так и errors.Is(err, ErrNotFound).
+var (
+    ErrNotFound           = errors.New("not found")
+    ErrConflict           = errors.New("conflict")
+    ErrValidation         = errors.New("validation failed")
+    ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+var (
+    ErrSubscriptionNotFound = fmt.Errorf("subscription %w", ErrNotFound)
+    ErrSubscriptionExists   = fmt.Errorf("%w: subscription already exists for this user and service with the same start date", ErrConflict)
+)
+
+// uniqueViolation - код ошибки PostgreSQL при нарушении уникального ограничения
+const uniqueViolation = "23505"
+
+func isDuplicateError(err error) bool {
+    var pqErr *pq.Error
+    return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
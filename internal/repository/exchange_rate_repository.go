@@ -0,0 +1,158 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "sort"
+    "time"
+
+    "subscription-service/internal/models"
+)
+
+// ErrExchangeRateNotFound - данные запроса нельзя обработать: для пересчета нет курса
+var ErrExchangeRateNotFound = fmt.Errorf("%w: exchange rate not found", ErrValidation)
+
+type ExchangeRateRepository interface {
+    Upsert(ctx context.Context, rates []models.ExchangeRate) error
+    List(ctx context.Context, currency *string) ([]models.ExchangeRate, error)
+}
+
+type exchangeRateRepo struct {
+    db *sql.DB
+}
+
+func NewExchangeRateRepository(db *sql.DB) ExchangeRateRepository {
+    return &exchangeRateRepo{db: db}
+}
+
+func (r *exchangeRateRepo) Upsert(ctx context.Context, rates []models.ExchangeRate) error {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    query := `
+        INSERT INTO exchange_rates (from_currency, to_currency, effective_date, rate)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (from_currency, to_currency, effective_date)
+        DO UPDATE SET rate = EXCLUDED.rate
+    `
+
+    for _, rate := range rates {
+        _, err := tx.ExecContext(ctx, query, rate.FromCurrency, rate.ToCurrency, rate.EffectiveDate, rate.Rate)
+        if err != nil {
+            log.Printf("Error saving exchange rate %s/%s: %v", rate.FromCurrency, rate.ToCurrency, err)
+            return fmt.Errorf("failed to save exchange rate: %w", err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit exchange rates: %w", err)
+    }
+
+    log.Printf("Saved %d exchange rates", len(rates))
+    return nil
+}
+
+func (r *exchangeRateRepo) List(ctx context.Context, currency *string) ([]models.ExchangeRate, error) {
+    rates, err := queryExchangeRates(ctx, r.db, currency)
+    if err != nil {
+        log.Printf("Error listing exchange rates: %v", err)
+        return nil, fmt.Errorf("failed to list exchange rates: %w", err)
+    }
+    return rates, nil
+}
+
+func queryExchangeRates(ctx context.Context, db *sql.DB, currency *string) ([]models.ExchangeRate, error) {
+    query := `
+        SELECT from_currency, to_currency, effective_date, rate
+        FROM exchange_rates
+    `
+    args := []interface{}{}
+
+    if currency != nil {
+        query += " WHERE from_currency = $1 OR to_currency = $1"
+        args = append(args, *currency)
+    }
+
+    query += " ORDER BY from_currency, to_currency, effective_date"
+
+    rows, err := db.QueryContext(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    rates := []models.ExchangeRate{}
+    for rows.Next() {
+        var rate models.ExchangeRate
+        if err := rows.Scan(&rate.FromCurrency, &rate.ToCurrency, &rate.EffectiveDate, &rate.Rate); err != nil {
+            return nil, fmt.Errorf("failed to scan exchange rate: %w", err)
+        }
+        rates = append(rates, rate)
+    }
+
+    return rates, rows.Err()
+}
+
+// rateTable переводит суммы в валюту отчета по курсу, действовавшему на нужную дату.
+// Нулевая таблица (nil) означает, что суммы не пересчитываются.
+type rateTable struct {
+    target string
+    pairs  map[string][]models.ExchangeRate
+}
+
+func loadRateTable(ctx context.Context, db *sql.DB, target *string) (*rateTable, error) {
+    if target == nil {
+        return nil, nil
+    }
+
+    rates, err := queryExchangeRates(ctx, db, target)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load exchange rates: %w", err)
+    }
+
+    table := &rateTable{target: *target, pairs: map[string][]models.ExchangeRate{}}
+    for _, rate := range rates {
+        key := rate.FromCurrency + "/" + rate.ToCurrency
+        table.pairs[key] = append(table.pairs[key], rate)
+    }
+    return table, nil
+}
+
+func (t *rateTable) currency() string {
+    if t == nil {
+        return ""
+    }
+    return t.target
+}
+
+func (t *rateTable) convert(amount models.Money, currency string, on time.Time) (models.Money, error) {
+    if t == nil || currency == t.target {
+        return amount, nil
+    }
+
+    if rate, ok := t.rateOn(currency+"/"+t.target, on); ok {
+        return amount.Convert(rate), nil
+    }
+    if rate, ok := t.rateOn(t.target+"/"+currency, on); ok {
+        return amount.Convert(rate.Inverse()), nil
+    }
+
+    return 0, fmt.Errorf("%w: %s to %s on %s", ErrExchangeRateNotFound, currency, t.target, on.Format("2006-01-02"))
+}
+
+// rateOn возвращает последний курс пары, вступивший в силу не позже даты on
+func (t *rateTable) rateOn(pair string, on time.Time) (models.Rate, bool) {
+    rates := t.pairs[pair]
+    i := sort.Search(len(rates), func(i int) bool {
+        return rates[i].EffectiveDate.After(on)
+    })
+    if i == 0 {
+        return models.Rate{}, false
+    }
+    return rates[i-1].Rate, true
+}
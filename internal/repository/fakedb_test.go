@@ -0,0 +1,151 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeStep - ожидаемый запрос и ответ на него. query - часть текста запроса; rows отдаются для Query,
+// для Exec ответ - одна затронутая строка
+type fakeStep struct {
+    query   string
+    columns []string
+    rows    [][]driver.Value
+    err     error
+    // respond, если задан, выполняется вместо rows: так шаг может менять состояние, которое видят следующие
+    respond func() [][]driver.Value
+}
+
+// fakeDB - драйвер database/sql, отвечающий на запросы по сценарию в заданном порядке.
+// С ним тесты проходят настоящий SQL-код репозитория без Postgres.
+type fakeDB struct {
+    mu    sync.Mutex
+    steps []fakeStep
+    // log - выполненные запросы, включая BEGIN, COMMIT и ROLLBACK
+    log []string
+}
+
+func newFakeDB(t *testing.T, steps ...fakeStep) (*sql.DB, *fakeDB) {
+    t.Helper()
+
+    fake := &fakeDB{steps: steps}
+    db := sql.OpenDB(fake)
+    t.Cleanup(func() {
+        db.Close()
+        fake.mu.Lock()
+        defer fake.mu.Unlock()
+        if len(fake.steps) > 0 {
+            t.Errorf("queries not executed: %q", fake.steps[0].query)
+        }
+    })
+    return db, fake
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) {
+    return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+    return nil
+}
+
+func (f *fakeDB) record(query string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.log = append(f.log, query)
+}
+
+// next возвращает шаг для запроса query или ошибку, если сценарий ожидал другой запрос
+func (f *fakeDB) next(query string) (fakeStep, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.log = append(f.log, query)
+    if len(f.steps) == 0 {
+        return fakeStep{}, fmt.Errorf("unexpected query: %s", query)
+    }
+    step := f.steps[0]
+    if !strings.Contains(query, step.query) {
+        return fakeStep{}, fmt.Errorf("query %q does not contain %q", query, step.query)
+    }
+    f.steps = f.steps[1:]
+    if step.respond != nil {
+        step.rows = step.respond()
+    }
+    return step, step.err
+}
+
+type fakeConn struct {
+    db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+    return nil, fmt.Errorf("prepared statements are not supported: %s", query)
+}
+
+func (c *fakeConn) Close() error {
+    return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+    c.db.record("BEGIN")
+    return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+    c.db.record("COMMIT")
+    return nil
+}
+
+func (c *fakeConn) Rollback() error {
+    c.db.record("ROLLBACK")
+    return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+    // Точки сохранения в сценарии не описываются: они не меняют ответы
+    if strings.HasPrefix(query, "SAVEPOINT ") || strings.HasPrefix(query, "RELEASE SAVEPOINT ") || strings.HasPrefix(query, "ROLLBACK TO SAVEPOINT ") {
+        c.db.record(query)
+        return driver.RowsAffected(0), nil
+    }
+    if _, err := c.db.next(query); err != nil {
+        return nil, err
+    }
+    return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+    step, err := c.db.next(query)
+    if err != nil {
+        return nil, err
+    }
+    return &fakeRows{columns: step.columns, rows: step.rows}, nil
+}
+
+type fakeRows struct {
+    columns []string
+    rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+    return r.columns
+}
+
+func (r *fakeRows) Close() error {
+    return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+    if len(r.rows) == 0 {
+        return io.EOF
+    }
+    copy(dest, r.rows[0])
+    r.rows = r.rows[1:]
+    return nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+
+    "subscription-service/internal/models"
+)
+
+type IdempotencyRepository interface {
+    Reserve(ctx context.Context, scope, key, requestHash string, expiresAt time.Time) (*models.IdempotencyRecord, bool, error)
+    Complete(ctx context.Context, scope, key string, statusCode int, contentType string, body []byte) error
+    Release(ctx context.Context, scope, key string) error
+    DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type idempotencyRepo struct {
+    db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) IdempotencyRepository {
+    return &idempotencyRepo{db: db}
+}
+
+// Reserve закрепляет ключ в области scope за текущим запросом. Если ключ уже занят и не истек,
+// возвращает сохраненную запись и reserved = false.
+func (r *idempotencyRepo) Reserve(ctx context.Context, scope, key, requestHash string, expiresAt time.Time) (*models.IdempotencyRecord, bool, error) {
+    // Истекший ключ переиспользуется так же, как новый
+    query := `
+        INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (scope, key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            status_code = NULL,
+            content_type = NULL,
+            response_body = NULL,
+            created_at = CURRENT_TIMESTAMP,
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+        RETURNING key
+    `
+
+    var reserved string
+    err := r.db.QueryRowContext(ctx, query, scope, key, requestHash, expiresAt).Scan(&reserved)
+    if err == nil {
+        return nil, true, nil
+    }
+    if err != sql.ErrNoRows {
+        log.Printf("Error reserving idempotency key: %v", err)
+        return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+    }
+
+    query = `
+        SELECT scope, key, request_hash, status_code, content_type, response_body, created_at, expires_at
+        FROM idempotency_keys
+        WHERE scope = $1 AND key = $2
+    `
+
+    var (
+        record      models.IdempotencyRecord
+        statusCode  sql.NullInt64
+        contentType sql.NullString
+    )
+    err = r.db.QueryRowContext(ctx, query, scope, key).Scan(
+        &record.Scope,
+        &record.Key,
+        &record.RequestHash,
+        &statusCode,
+        &contentType,
+        &record.Body,
+        &record.CreatedAt,
+        &record.ExpiresAt,
+    )
+    if err != nil {
+        // Ключ освободили между двумя запросами: для клиента это все еще выполняющийся запрос
+        if err == sql.ErrNoRows {
+            return &models.IdempotencyRecord{Scope: scope, Key: key, RequestHash: requestHash}, false, nil
+        }
+        log.Printf("Error getting idempotency key: %v", err)
+        return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+    }
+
+    if statusCode.Valid {
+        status := int(statusCode.Int64)
+        record.StatusCode = &status
+    }
+    record.ContentType = contentType.String
+
+    return &record, false, nil
+}
+
+// Complete сохраняет ответ на запрос, за которым закреплен ключ
+func (r *idempotencyRepo) Complete(ctx context.Context, scope, key string, statusCode int, contentType string, body []byte) error {
+    query := `
+        UPDATE idempotency_keys
+        SET status_code = $3, content_type = $4, response_body = $5
+        WHERE scope = $1 AND key = $2
+    `
+
+    if _, err := r.db.ExecContext(ctx, query, scope, key, statusCode, contentType, body); err != nil {
+        log.Printf("Error saving idempotent response: %v", err)
+        return fmt.Errorf("failed to save idempotent response: %w", err)
+    }
+    return nil
+}
+
+// Release освобождает ключ, если запрос не удался, чтобы клиент мог его повторить
+func (r *idempotencyRepo) Release(ctx context.Context, scope, key string) error {
+    query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status_code IS NULL`
+
+    if _, err := r.db.ExecContext(ctx, query, scope, key); err != nil {
+        log.Printf("Error releasing idempotency key: %v", err)
+        return fmt.Errorf("failed to release idempotency key: %w", err)
+    }
+    return nil
+}
+
+func (r *idempotencyRepo) DeleteExpired(ctx context.Context) (int64, error) {
+    result, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP`)
+    if err != nil {
+        log.Printf("Error deleting expired idempotency keys: %v", err)
+        return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("failed to get rows affected: %w", err)
+    }
+    return rows, nil
+}
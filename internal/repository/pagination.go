@@ -0,0 +1,71 @@
+package repository
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+)
+
+var ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrValidation)
+
+// sortColumns сопоставляет поле сортировки с SQL-типом, к которому приводится значение из курсора
+var sortColumns = map[string]string{
+    models.SortCreatedAt:   "timestamptz",
+    models.SortPrice:       "numeric",
+    models.SortStartDate:   "date",
+    models.SortServiceName: "text",
+    models.SortRelevance:   "real",
+}
+
+// pageCursor - позиция последней строки страницы. Сортировка и направление хранятся в курсоре,
+// чтобы курсор нельзя было применить к выдаче с другим порядком.
+type pageCursor struct {
+    Sort  string    `json:"s"`
+    Order string    `json:"o"`
+    Value string    `json:"v"`
+    ID    uuid.UUID `json:"id"`
+}
+
+func encodeCursor(page *models.PageRequest, value string, id uuid.UUID) string {
+    data, _ := json.Marshal(pageCursor{
+        Sort:  page.Sort,
+        Order: page.Order,
+        Value: value,
+        ID:    id,
+    })
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(page *models.PageRequest) (*pageCursor, error) {
+    data, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+    if err != nil {
+        return nil, ErrInvalidCursor
+    }
+
+    var c pageCursor
+    if err := json.Unmarshal(data, &c); err != nil {
+        return nil, ErrInvalidCursor
+    }
+
+    if c.Sort != page.Sort || c.Order != page.Order {
+        return nil, fmt.Errorf("%w: cursor was issued for sort=%s order=%s", ErrInvalidCursor, c.Sort, c.Order)
+    }
+    return &c, nil
+}
+
+func sortValue(sort string, sub *models.Subscription) string {
+    switch sort {
+    case models.SortPrice:
+        return sub.Price.String()
+    case models.SortStartDate:
+        return sub.StartDate.Format("2006-01-02")
+    case models.SortServiceName:
+        return sub.ServiceName
+    default:
+        return sub.CreatedAt.Format(time.RFC3339Nano)
+    }
+}
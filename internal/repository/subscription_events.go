@@ -0,0 +1,109 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+    "subscription-service/internal/requestctx"
+)
+
+// snapshotColumn - снимок строки подписки для журнала изменений (без вычисляемых колонок)
+const snapshotColumn = "to_jsonb(subscriptions.*) - 'service_name_normalized'"
+
+// recordEvent пишет событие журнала в той же транзакции, что и само изменение.
+// Инициатор и ID запроса берутся из контекста.
+func recordEvent(ctx context.Context, tx querier, subscriptionID uuid.UUID, eventType string, before, after []byte) error {
+    query := `
+        INSERT INTO subscription_events (subscription_id, event_type, before, after, actor, request_id)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `
+
+    _, err := tx.ExecContext(
+        ctx,
+        query,
+        subscriptionID,
+        eventType,
+        nullJSON(before),
+        nullJSON(after),
+        nullString(requestctx.Actor(ctx)),
+        nullString(requestctx.RequestID(ctx)),
+    )
+    if err != nil {
+        log.Printf("Error recording %s event for subscription %s: %v", eventType, subscriptionID, err)
+        return fmt.Errorf("failed to record subscription event: %w", err)
+    }
+    return nil
+}
+
+// lockSnapshot блокирует подписку до конца транзакции и возвращает ее снимок.
+// deleted выбирает, среди каких подписок искать: удаленных или действующих.
+func lockSnapshot(ctx context.Context, tx querier, id uuid.UUID, deleted bool) ([]byte, error) {
+    query := `SELECT ` + snapshotColumn + ` FROM subscriptions WHERE id = $1 AND (deleted_at IS NOT NULL) = $2 FOR UPDATE`
+
+    var snapshot []byte
+    if err := tx.QueryRowContext(ctx, query, id, deleted).Scan(&snapshot); err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrSubscriptionNotFound
+        }
+        return nil, fmt.Errorf("failed to lock subscription: %w", err)
+    }
+    return snapshot, nil
+}
+
+func (r *subscriptionRepo) History(ctx context.Context, id uuid.UUID) ([]models.SubscriptionEvent, error) {
+    query := `
+        SELECT id, subscription_id, event_type, before, after, actor, request_id, created_at
+        FROM subscription_events
+        WHERE subscription_id = $1
+        ORDER BY created_at, id
+    `
+
+    rows, err := r.conn(ctx).QueryContext(ctx, query, id)
+    if err != nil {
+        log.Printf("Error getting history of subscription %s: %v", id, err)
+        return nil, fmt.Errorf("failed to get subscription history: %w", err)
+    }
+    defer rows.Close()
+
+    events := []models.SubscriptionEvent{}
+    for rows.Next() {
+        var event models.SubscriptionEvent
+        var before, after []byte
+        err := rows.Scan(
+            &event.ID,
+            &event.SubscriptionID,
+            &event.Type,
+            &before,
+            &after,
+            &event.Actor,
+            &event.RequestID,
+            &event.CreatedAt,
+        )
+        if err != nil {
+            return nil, fmt.Errorf("failed to scan subscription event: %w", err)
+        }
+        event.Before = before
+        event.After = after
+        events = append(events, event)
+    }
+
+    return events, rows.Err()
+}
+
+func nullJSON(data []byte) interface{} {
+    if data == nil {
+        return nil
+    }
+    return string(data)
+}
+
+func nullString(s string) interface{} {
+    if s == "" {
+        return nil
+    }
+    return s
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/lib/pq"
+    "subscription-service/internal/models"
+)
+
+// ErrPriceChangeExists возвращается, если на эту дату изменение цены уже запланировано
+var ErrPriceChangeExists = fmt.Errorf("%w: a price change with this effective date already exists", ErrConflict)
+
+func (r *subscriptionRepo) AddPriceChange(ctx context.Context, id uuid.UUID, change *models.PriceChange) error {
+    tx, err := beginTx(ctx, r.db)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if _, err := lockSnapshot(ctx, tx, id, false); err != nil {
+        return err
+    }
+
+    query := `
+        INSERT INTO subscription_prices (subscription_id, effective_from, price)
+        VALUES ($1, $2, $3)
+        RETURNING created_at
+    `
+
+    err = tx.QueryRowContext(ctx, query, id, change.EffectiveFrom, change.Price).Scan(&change.CreatedAt)
+    if err != nil {
+        if isDuplicateError(err) {
+            return ErrPriceChangeExists
+        }
+        log.Printf("Error adding price change for subscription %s: %v", id, err)
+        return fmt.Errorf("failed to add price change: %w", err)
+    }
+
+    // Изменение цены меняет представление подписки, поэтому ее версия тоже увеличивается
+    _, err = tx.ExecContext(ctx, `UPDATE subscriptions SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+    if err != nil {
+        return fmt.Errorf("failed to bump subscription version: %w", err)
+    }
+
+    after, err := json.Marshal(change)
+    if err != nil {
+        return fmt.Errorf("failed to encode price change: %w", err)
+    }
+    if err := recordEvent(ctx, tx, id, models.EventRepriced, nil, after); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit price change: %w", err)
+    }
+
+    log.Printf("Scheduled price change for subscription %s from %s", id, change.EffectiveFrom.Format("2006-01-02"))
+    return nil
+}
+
+// attachPrices загружает изменения цены для подписок и пересчитывает их вычисляемые поля.
+// Для среза на момент asOf изменения, внесенные позже, не учитываются.
+func (r *subscriptionRepo) attachPrices(ctx context.Context, subscriptions []*models.Subscription, asOf *time.Time) error {
+    if len(subscriptions) == 0 {
+        return nil
+    }
+
+    byID := make(map[uuid.UUID]*models.Subscription, len(subscriptions))
+    ids := make([]string, 0, len(subscriptions))
+    for _, sub := range subscriptions {
+        byID[sub.ID] = sub
+        ids = append(ids, sub.ID.String())
+    }
+
+    query := `
+        SELECT subscription_id, effective_from, price, created_at
+        FROM subscription_prices
+        WHERE subscription_id = ANY($1::uuid[]) AND ($2::timestamptz IS NULL OR created_at <= $2)
+        ORDER BY subscription_id, effective_from
+    `
+
+    rows, err := r.conn(ctx).QueryContext(ctx, query, pq.Array(ids), asOf)
+    if err != nil {
+        return fmt.Errorf("failed to load price changes: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var id uuid.UUID
+        var change models.PriceChange
+        if err := rows.Scan(&id, &change.EffectiveFrom, &change.Price, &change.CreatedAt); err != nil {
+            return fmt.Errorf("failed to scan price change: %w", err)
+        }
+        if sub, ok := byID[id]; ok {
+            sub.PriceChanges = append(sub.PriceChanges, change)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return fmt.Errorf("failed to load price changes: %w", err)
+    }
+
+    now := time.Now().UTC()
+    if asOf != nil {
+        now = asOf.UTC()
+    }
+    for _, sub := range subscriptions {
+        sub.FillDerived(now)
+    }
+    return nil
+}
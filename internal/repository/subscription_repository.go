@@ -5,20 +5,95 @@ import (
     "database/sql"
     "fmt"
     "log"
+    "sort"
+    "strconv"
     "strings"
     "time"
 
     "github.com/google/uuid"
+    "github.com/lib/pq"
     "subscription-service/internal/models"
+    "subscription-service/internal/requestctx"
 )
 
 type SubscriptionRepository interface {
+    // WithinTx выполняет fn в одной транзакции; вложенный вызов работает через точку сохранения
+    WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
     Create(ctx context.Context, sub *models.Subscription) error
-    GetByID(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
-    Update(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest) error
-    Delete(ctx context.Context, id uuid.UUID) error
-    List(ctx context.Context, userID *uuid.UUID, serviceName *string) ([]*models.Subscription, error)
+    GetByID(ctx context.Context, id uuid.UUID, asOf *time.Time) (*models.Subscription, error)
+    GetByKey(ctx context.Context, userID uuid.UUID, serviceName string, startDate time.Time) (*models.Subscription, error)
+    GetDeleted(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
+    CountActiveByUser(ctx context.Context, userID uuid.UUID, except uuid.UUID) (int, error)
+    CountActiveByUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]int, error)
+    // LockUsers блокирует подписки пользователей до конца транзакции из ctx (см. WithinTx)
+    LockUsers(ctx context.Context, userIDs ...uuid.UUID) error
+    // CreateMany добавляет подписки одним запросом; created[i] - создана ли subs[i]: дубликаты пропускаются
+    CreateMany(ctx context.Context, subs []*models.Subscription) (created []bool, err error)
+    // ExistingKeys сообщает для каждой подписки, есть ли уже действующая подписка с тем же естественным ключом
+    ExistingKeys(ctx context.Context, subs []*models.Subscription) ([]bool, error)
+    Upsert(ctx context.Context, sub *models.Subscription) (created bool, err error)
+    Update(ctx context.Context, sub *models.Subscription, ifMatch []int64) error
+    Delete(ctx context.Context, id uuid.UUID, deletedAt time.Time, ifMatch []int64) error
+    Restore(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
+    Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+    History(ctx context.Context, id uuid.UUID) ([]models.SubscriptionEvent, error)
+    AddPriceChange(ctx context.Context, id uuid.UUID, change *models.PriceChange) error
+    List(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest) (*models.SubscriptionPage, error)
+    ExportList(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest, fn func(sub *models.Subscription) error) error
     GetSummary(ctx context.Context, req *models.SummaryRequest) (*models.SubscriptionSummary, error)
+    ExportSummary(ctx context.Context, req *models.SummaryRequest, fn func(item *models.SubscriptionCost) error) error
+    GetTimeSeries(ctx context.Context, req *models.TimeSeriesRequest) (*models.TimeSeries, error)
+    ListUpcomingCharges(ctx context.Context, req *models.UpcomingRequest) (*models.UpcomingCharges, error)
+}
+
+const subscriptionColumns = "id, service_id, service_name, price, currency, billing_period, billing_anchor_day, user_id, start_date, end_date, created_at, updated_at, deleted_at, version"
+
+// subscriptionsAsOf возвращает источник строк для FROM: саму таблицу subscriptions
+// или срез ее версий на момент asOf под тем же именем, чтобы остальной запрос не менялся
+func subscriptionsAsOf(asOf *time.Time) string {
+    if asOf == nil {
+        return "subscriptions"
+    }
+
+    // Момент форматируется из time.Time, а не берется из ввода, поэтому его можно подставить литералом
+    at := pq.QuoteLiteral(asOf.UTC().Format(time.RFC3339Nano)) + "::timestamptz"
+    return `(
+            SELECT ` + subscriptionColumns + `, service_name_normalized
+            FROM subscription_versions
+            WHERE valid_from <= ` + at + ` AND (valid_to IS NULL OR valid_to > ` + at + `)
+        ) AS subscriptions`
+}
+
+// rowScanner - общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// scanSubscription читает строку, выбранную по subscriptionColumns; extra - приемники для дополнительных колонок после них
+func scanSubscription(row rowScanner, extra ...interface{}) (*models.Subscription, error) {
+    var sub models.Subscription
+    dest := []interface{}{
+        &sub.ID,
+        &sub.ServiceID,
+        &sub.ServiceName,
+        &sub.Price,
+        &sub.Currency,
+        &sub.BillingPeriod,
+        &sub.BillingAnchorDay,
+        &sub.UserID,
+        &sub.StartDate,
+        &sub.EndDate,
+        &sub.CreatedAt,
+        &sub.UpdatedAt,
+        &sub.DeletedAt,
+        &sub.Version,
+    }
+    if err := row.Scan(append(dest, extra...)...); err != nil {
+        return nil, err
+    }
+
+    sub.FillDerived(time.Now().UTC())
+    return &sub, nil
 }
 
 type subscriptionRepo struct {
@@ -29,235 +104,1197 @@ func NewSubscriptionRepository(db *sql.DB) SubscriptionRepository {
     return &subscriptionRepo{db: db}
 }
 
+func (r *subscriptionRepo) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return withinTx(ctx, r.db, fn)
+}
+
+// conn возвращает транзакцию, начатую через WithinTx, или пул соединений
+func (r *subscriptionRepo) conn(ctx context.Context) querier {
+    return connFor(ctx, r.db)
+}
+
+// streamChunkSize - число строк, для которых изменения цены загружаются одним запросом при потоковом чтении
+const streamChunkSize = 500
+
+// streamConn возвращает пул соединений для потокового чтения через eachSubscription. Пока курсор открыт,
+// eachSubscription загружает изменения цены отдельными запросами, а соединение транзакции занято курсором,
+// поэтому в транзакции такое чтение не работает и сразу возвращает ошибку.
+func (r *subscriptionRepo) streamConn(ctx context.Context) (querier, error) {
+    if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+        return nil, fmt.Errorf("streaming subscriptions is not supported inside a transaction")
+    }
+    return r.db, nil
+}
+
+// naturalKeyConflict - условие ON CONFLICT по естественному ключу подписки (индекс unique_user_service_active)
+const naturalKeyConflict = "ON CONFLICT (user_id, service_name_normalized, start_date) WHERE deleted_at IS NULL"
+
+// naturalKeyFilter выбирает действующую подписку по естественному ключу; $1 - пользователь,
+// $2 - нормализованное название сервиса, $3 - дата начала
+const naturalKeyFilter = "user_id = $1 AND service_name_normalized = $2 AND start_date = $3 AND deleted_at IS NULL"
+
+// Create добавляет подписку. Дубликат по пользователю, сервису и дате начала отсекается
+// тем же INSERT через ON CONFLICT, поэтому одновременные запросы не создадут две подписки.
 func (r *subscriptionRepo) Create(ctx context.Context, sub *models.Subscription) error {
-    existing, err := r.findExistingSubscription(ctx, sub.UserID, sub.ServiceName, sub.StartDate)
+    tx, err := beginTx(ctx, r.db)
     if err != nil {
-        return fmt.Errorf("failed to check existing subscription: %w", err)
-    }
-    
-    if existing != nil {
-        return fmt.Errorf("subscription already exists for user %s to service %s starting from %s", 
-            sub.UserID, sub.ServiceName, sub.StartDate.Format("2006-01-02"))
+        return fmt.Errorf("failed to begin transaction: %w", err)
     }
+    defer tx.Rollback()
 
     query := `
-        INSERT INTO subscriptions (service_name, price, user_id, start_date, end_date)
-        VALUES ($1, $2, $3, $4, $5)
-        RETURNING id, created_at, updated_at
+        INSERT INTO subscriptions (service_id, service_name, price, currency, billing_period, billing_anchor_day, user_id, start_date, end_date)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ` + naturalKeyConflict + ` DO NOTHING
+        RETURNING id, created_at, updated_at, ` + snapshotColumn + `
     `
 
-    err = r.db.QueryRowContext(
+    var snapshot []byte
+    err = tx.QueryRowContext(
         ctx,
         query,
+        sub.ServiceID,
         sub.ServiceName,
         sub.Price,
+        sub.Currency,
+        sub.BillingPeriod,
+        sub.BillingAnchorDay,
         sub.UserID,
         sub.StartDate,
         sub.EndDate,
-    ).Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
+    ).Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt, &snapshot)
 
     if err != nil {
-        if isDuplicateError(err) {
-            return fmt.Errorf("subscription already exists for this user and service")
+        if err == sql.ErrNoRows || isDuplicateError(err) {
+            return ErrSubscriptionExists
         }
         log.Printf("Error creating subscription: %v", err)
         return fmt.Errorf("failed to create subscription: %w", err)
     }
 
+    if err := recordEvent(ctx, tx, sub.ID, models.EventCreated, nil, snapshot); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit subscription: %w", err)
+    }
+
+    sub.FillDerived(time.Now().UTC())
+
     log.Printf("Created subscription with ID: %s", sub.ID)
     return nil
 }
 
-func (r *subscriptionRepo) findExistingSubscription(ctx context.Context, userID uuid.UUID, serviceName string, startDate time.Time) (*models.Subscription, error) {
+// createManyColumns - число параметров одной строки в CreateMany
+const createManyColumns = 9
+
+// CreateMany добавляет подписки одним многострочным INSERT и пишет для созданных события журнала в том же запросе.
+// Подписки с уже занятым естественным ключом пропускаются через ON CONFLICT; ключи внутри subs должны быть
+// различны, иначе строки нельзя сопоставить с результатом. Созданные подписки заполняются как в Create.
+func (r *subscriptionRepo) CreateMany(ctx context.Context, subs []*models.Subscription) ([]bool, error) {
+    created := make([]bool, len(subs))
+    if len(subs) == 0 {
+        return created, nil
+    }
+
+    values := make([]string, len(subs))
+    args := make([]interface{}, 0, len(subs)*createManyColumns+3)
+    byKey := make(map[string]int, len(subs))
+    for i, sub := range subs {
+        placeholders := make([]string, createManyColumns)
+        for j := range placeholders {
+            placeholders[j] = "$" + strconv.Itoa(i*createManyColumns+j+1)
+        }
+        values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+        args = append(args, sub.ServiceID, sub.ServiceName, sub.Price, sub.Currency, sub.BillingPeriod,
+            sub.BillingAnchorDay, sub.UserID, sub.StartDate, sub.EndDate)
+        byKey[sub.NaturalKey()] = i
+    }
+    n := len(args)
+    args = append(args, models.EventCreated, nullString(requestctx.Actor(ctx)), nullString(requestctx.RequestID(ctx)))
+
     query := `
-        SELECT id, service_name, price, user_id, start_date, end_date, created_at, updated_at
-        FROM subscriptions 
-        WHERE user_id = $1 AND service_name = $2 AND start_date = $3
-        LIMIT 1
+        WITH inserted AS (
+            INSERT INTO subscriptions (service_id, service_name, price, currency, billing_period, billing_anchor_day, user_id, start_date, end_date)
+            VALUES ` + strings.Join(values, ", ") + `
+            ` + naturalKeyConflict + ` DO NOTHING
+            RETURNING id, user_id, service_name, start_date, created_at, updated_at, ` + snapshotColumn + ` AS snapshot
+        ), events AS (
+            INSERT INTO subscription_events (subscription_id, event_type, after, actor, request_id)
+            SELECT id, $` + strconv.Itoa(n+1) + `, snapshot, $` + strconv.Itoa(n+2) + `, $` + strconv.Itoa(n+3) + `
+            FROM inserted
+        )
+        SELECT id, user_id, service_name, start_date, created_at, updated_at FROM inserted
     `
 
-    var sub models.Subscription
-    err := r.db.QueryRowContext(ctx, query, userID, serviceName, startDate).Scan(
-        &sub.ID,
-        &sub.ServiceName,
-        &sub.Price,
-        &sub.UserID,
-        &sub.StartDate,
-        &sub.EndDate,
-        &sub.CreatedAt,
-        &sub.UpdatedAt,
-    )
+    rows, err := r.conn(ctx).QueryContext(ctx, query, args...)
+    if err != nil {
+        log.Printf("Error creating %d subscriptions: %v", len(subs), err)
+        return nil, fmt.Errorf("failed to create subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    now := time.Now().UTC()
+    for rows.Next() {
+        var key models.Subscription
+        var id uuid.UUID
+        var createdAt, updatedAt time.Time
+        if err := rows.Scan(&id, &key.UserID, &key.ServiceName, &key.StartDate, &createdAt, &updatedAt); err != nil {
+            return nil, fmt.Errorf("failed to scan created subscription: %w", err)
+        }
+
+        i, ok := byKey[key.NaturalKey()]
+        if !ok {
+            return nil, fmt.Errorf("created subscription %s does not match any input row", id)
+        }
+        sub := subs[i]
+        sub.ID, sub.CreatedAt, sub.UpdatedAt = id, createdAt, updatedAt
+        sub.FillDerived(now)
+        created[i] = true
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read created subscriptions: %w", err)
+    }
+
+    log.Printf("Created %d of %d subscriptions", countTrue(created), len(subs))
+    return created, nil
+}
+
+// ExistingKeys проверяет естественные ключи subs среди действующих подписок одним запросом
+func (r *subscriptionRepo) ExistingKeys(ctx context.Context, subs []*models.Subscription) ([]bool, error) {
+    exists := make([]bool, len(subs))
+    if len(subs) == 0 {
+        return exists, nil
+    }
+
+    userIDs := make([]string, len(subs))
+    names := make([]string, len(subs))
+    startDates := make([]string, len(subs))
+    for i, sub := range subs {
+        userIDs[i] = sub.UserID.String()
+        names[i] = models.NormalizeServiceName(sub.ServiceName)
+        startDates[i] = sub.StartDate.UTC().Format("2006-01-02")
+    }
+
+    query := `
+        SELECT k.ord
+        FROM unnest($1::uuid[], $2::text[], $3::date[]) WITH ORDINALITY AS k(user_id, service_name_normalized, start_date, ord)
+        WHERE EXISTS (
+            SELECT 1
+            FROM subscriptions s
+            WHERE s.user_id = k.user_id
+              AND s.service_name_normalized = k.service_name_normalized
+              AND s.start_date = k.start_date
+              AND s.deleted_at IS NULL
+        )
+    `
 
+    rows, err := r.conn(ctx).QueryContext(ctx, query, pq.Array(userIDs), pq.Array(names), pq.Array(startDates))
     if err != nil {
-        if err == sql.ErrNoRows {
-            return nil, nil
+        log.Printf("Error checking %d subscription keys: %v", len(subs), err)
+        return nil, fmt.Errorf("failed to check subscription keys: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var ord int
+        if err := rows.Scan(&ord); err != nil {
+            return nil, fmt.Errorf("failed to scan subscription key: %w", err)
         }
-        return nil, err
+        exists[ord-1] = true
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read subscription keys: %w", err)
     }
+    return exists, nil
+}
 
-    return &sub, nil
+func countTrue(values []bool) int {
+    n := 0
+    for _, v := range values {
+        if v {
+            n++
+        }
+    }
+    return n
 }
 
-func isDuplicateError(err error) bool {
-    if err == nil {
-        return false
+// GetByKey возвращает действующую подписку по естественному ключу: пользователю, сервису и дате начала
+func (r *subscriptionRepo) GetByKey(ctx context.Context, userID uuid.UUID, serviceName string, startDate time.Time) (*models.Subscription, error) {
+    query := `
+        SELECT ` + subscriptionColumns + `
+        FROM subscriptions
+        WHERE ` + naturalKeyFilter + `
+    `
+
+    sub, err := scanSubscription(r.conn(ctx).QueryRowContext(ctx, query, userID, models.NormalizeServiceName(serviceName), startDate))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrSubscriptionNotFound
+        }
+        log.Printf("Error getting subscription by key: %v", err)
+        return nil, fmt.Errorf("failed to get subscription: %w", err)
+    }
+
+    if err := r.attachPrices(ctx, []*models.Subscription{sub}, nil); err != nil {
+        return nil, fmt.Errorf("failed to get subscription: %w", err)
     }
-    errorString := err.Error()
-    return strings.Contains(errorString, "unique constraint") || 
-           strings.Contains(errorString, "duplicate key") ||
-           strings.Contains(errorString, "23505")
+
+    return sub, nil
 }
 
-func (r *subscriptionRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+// Upsert создает подписку или, если подписка с тем же пользователем, сервисом и датой начала уже есть,
+// заменяет остальные ее поля. Повтор с теми же данными ничего не меняет и не увеличивает версию.
+// created сообщает, была ли подписка создана.
+func (r *subscriptionRepo) Upsert(ctx context.Context, sub *models.Subscription) (created bool, err error) {
+    tx, err := beginTx(ctx, r.db)
+    if err != nil {
+        return false, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    // Существующая подписка блокируется, чтобы снимок "до" в журнале совпал с заменяемой строкой
+    lockQuery := `
+        SELECT ` + subscriptionColumns + `, ` + snapshotColumn + `
+        FROM subscriptions
+        WHERE ` + naturalKeyFilter + `
+        FOR UPDATE
+    `
+    key := []interface{}{sub.UserID, models.NormalizeServiceName(sub.ServiceName), sub.StartDate}
+
+    var before []byte
+    existing, err := scanSubscription(tx.QueryRowContext(ctx, lockQuery, key...), &before)
+    if err != nil && err != sql.ErrNoRows {
+        log.Printf("Error locking subscription by key: %v", err)
+        return false, fmt.Errorf("failed to upsert subscription: %w", err)
+    }
+
     query := `
-        SELECT id, service_name, price, user_id, start_date, end_date, created_at, updated_at
-        FROM subscriptions 
-        WHERE id = $1
+        INSERT INTO subscriptions (service_id, service_name, price, currency, billing_period, billing_anchor_day, user_id, start_date, end_date)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ` + naturalKeyConflict + ` DO UPDATE
+        SET service_id = EXCLUDED.service_id,
+            service_name = EXCLUDED.service_name,
+            price = EXCLUDED.price,
+            currency = EXCLUDED.currency,
+            billing_period = EXCLUDED.billing_period,
+            billing_anchor_day = EXCLUDED.billing_anchor_day,
+            end_date = EXCLUDED.end_date,
+            version = subscriptions.version + 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE (subscriptions.service_id, subscriptions.service_name, subscriptions.price, subscriptions.currency,
+               subscriptions.billing_period, subscriptions.billing_anchor_day, subscriptions.end_date)
+            IS DISTINCT FROM (EXCLUDED.service_id, EXCLUDED.service_name, EXCLUDED.price, EXCLUDED.currency,
+               EXCLUDED.billing_period, EXCLUDED.billing_anchor_day, EXCLUDED.end_date)
+        RETURNING ` + subscriptionColumns + `, ` + snapshotColumn + `, xmax = 0
     `
 
-    var sub models.Subscription
-    err := r.db.QueryRowContext(ctx, query, id).Scan(
-        &sub.ID,
-        &sub.ServiceName,
-        &sub.Price,
-        &sub.UserID,
-        &sub.StartDate,
-        &sub.EndDate,
-        &sub.CreatedAt,
-        &sub.UpdatedAt,
-    )
+    var after []byte
+    result, err := scanSubscription(tx.QueryRowContext(
+        ctx,
+        query,
+        sub.ServiceID,
+        sub.ServiceName,
+        sub.Price,
+        sub.Currency,
+        sub.BillingPeriod,
+        sub.BillingAnchorDay,
+        sub.UserID,
+        sub.StartDate,
+        sub.EndDate,
+    ), &after, &created)
 
+    switch {
+    case err == sql.ErrNoRows:
+        // Данные не изменились: возвращаем подписку как есть
+        if existing == nil {
+            existing, err = scanSubscription(tx.QueryRowContext(ctx, lockQuery, key...), &before)
+            if err != nil {
+                return false, fmt.Errorf("failed to upsert subscription: %w", err)
+            }
+        }
+        result, err = existing, nil
+    case err != nil:
+        log.Printf("Error upserting subscription: %v", err)
+        return false, fmt.Errorf("failed to upsert subscription: %w", err)
+    case created:
+        err = recordEvent(ctx, tx, result.ID, models.EventCreated, nil, after)
+    default:
+        err = recordEvent(ctx, tx, result.ID, models.EventUpdated, before, after)
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, fmt.Errorf("failed to commit subscription: %w", err)
+    }
+
+    if err := r.attachPrices(ctx, []*models.Subscription{result}, nil); err != nil {
+        return false, err
+    }
+    *sub = *result
+
+    log.Printf("Upserted subscription with ID: %s (created: %t)", sub.ID, created)
+    return created, nil
+}
+
+// ErrVersionMismatch возвращается, если подписку успели изменить после того, как клиент ее прочитал (If-Match)
+var ErrVersionMismatch = fmt.Errorf("%w: subscription has been modified by another request", ErrPreconditionFailed)
+
+// ErrRestoreConflict возвращается, если подписку нельзя восстановить: такая же подписка уже заведена заново
+var ErrRestoreConflict = fmt.Errorf("%w: an active subscription for this user and service with the same start date already exists", ErrConflict)
+
+// likeEscaper экранирует спецсимволы шаблона LIKE в пользовательском вводе
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (r *subscriptionRepo) GetByID(ctx context.Context, id uuid.UUID, asOf *time.Time) (*models.Subscription, error) {
+    query := `
+        SELECT ` + subscriptionColumns + `
+        FROM ` + subscriptionsAsOf(asOf) + ` 
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+
+    sub, err := scanSubscription(r.conn(ctx).QueryRowContext(ctx, query, id))
     if err != nil {
         if err == sql.ErrNoRows {
-            return nil, fmt.Errorf("subscription not found")
+            return nil, ErrSubscriptionNotFound
         }
         log.Printf("Error getting subscription by ID %s: %v", id, err)
         return nil, fmt.Errorf("failed to get subscription: %w", err)
     }
 
-    return &sub, nil
+    if err := r.attachPrices(ctx, []*models.Subscription{sub}, asOf); err != nil {
+        return nil, fmt.Errorf("failed to get subscription: %w", err)
+    }
+
+    return sub, nil
+}
+
+// GetDeleted возвращает подписку, помеченную удаленной
+func (r *subscriptionRepo) GetDeleted(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+    query := `
+        SELECT ` + subscriptionColumns + `
+        FROM subscriptions
+        WHERE id = $1 AND deleted_at IS NOT NULL
+    `
+
+    sub, err := scanSubscription(r.conn(ctx).QueryRowContext(ctx, query, id))
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, ErrSubscriptionNotFound
+        }
+        log.Printf("Error getting deleted subscription %s: %v", id, err)
+        return nil, fmt.Errorf("failed to get subscription: %w", err)
+    }
+
+    return sub, nil
+}
+
+// CountActiveByUser возвращает число неудаленных и незавершенных подписок пользователя, не считая подписку except
+func (r *subscriptionRepo) CountActiveByUser(ctx context.Context, userID uuid.UUID, except uuid.UUID) (int, error) {
+    query := `
+        SELECT COUNT(*)
+        FROM subscriptions
+        WHERE user_id = $1
+          AND id <> $2
+          AND deleted_at IS NULL
+          AND (end_date IS NULL OR end_date >= CURRENT_DATE)
+    `
+
+    var count int
+    if err := r.conn(ctx).QueryRowContext(ctx, query, userID, except).Scan(&count); err != nil {
+        log.Printf("Error counting subscriptions of user %s: %v", userID, err)
+        return 0, fmt.Errorf("failed to count subscriptions: %w", err)
+    }
+
+    return count, nil
 }
 
-func (r *subscriptionRepo) Update(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest) error {
+// CountActiveByUsers возвращает число неудаленных и незавершенных подписок каждого из пользователей;
+// пользователи без подписок в ответ не попадают
+func (r *subscriptionRepo) CountActiveByUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+    counts := make(map[uuid.UUID]int, len(userIDs))
+    if len(userIDs) == 0 {
+        return counts, nil
+    }
+
+    ids := make([]string, len(userIDs))
+    for i, id := range userIDs {
+        ids[i] = id.String()
+    }
+
+    query := `
+        SELECT user_id, COUNT(*)
+        FROM subscriptions
+        WHERE user_id = ANY($1::uuid[])
+          AND deleted_at IS NULL
+          AND (end_date IS NULL OR end_date >= CURRENT_DATE)
+        GROUP BY user_id
+    `
+
+    rows, err := r.conn(ctx).QueryContext(ctx, query, pq.Array(ids))
+    if err != nil {
+        log.Printf("Error counting subscriptions of %d users: %v", len(userIDs), err)
+        return nil, fmt.Errorf("failed to count subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var userID uuid.UUID
+        var count int
+        if err := rows.Scan(&userID, &count); err != nil {
+            return nil, fmt.Errorf("failed to scan subscription count: %w", err)
+        }
+        counts[userID] = count
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read subscription counts: %w", err)
+    }
+    return counts, nil
+}
+
+// LockUsers берет транзакционные рекомендательные блокировки на пользователей. Строк для SELECT ... FOR UPDATE
+// может еще не быть, а блокировка по user_id упорядочивает и создание первой подписки. Блокировки берутся
+// по возрастанию user_id, чтобы транзакции с общими пользователями не ждали друг друга по кругу.
+// Вне транзакции блокировки снимаются сразу после запроса и ничего не защищают.
+func (r *subscriptionRepo) LockUsers(ctx context.Context, userIDs ...uuid.UUID) error {
+    seen := make(map[uuid.UUID]bool, len(userIDs))
+    ids := make([]string, 0, len(userIDs))
+    for _, id := range userIDs {
+        if !seen[id] {
+            seen[id] = true
+            ids = append(ids, id.String())
+        }
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    sort.Strings(ids)
+
+    // unnest отдает элементы в порядке массива, поэтому блокировки берутся в порядке сортировки
+    query := `
+        SELECT pg_advisory_xact_lock(hashtextextended('subscriptions.user_id:' || id, 0))
+        FROM unnest($1::text[]) AS id
+    `
+
+    if _, err := r.conn(ctx).ExecContext(ctx, query, pq.Array(ids)); err != nil {
+        log.Printf("Error locking subscriptions of %d users: %v", len(ids), err)
+        return fmt.Errorf("failed to lock user subscriptions: %w", err)
+    }
+    return nil
+}
+
+// Update полностью заменяет изменяемые поля подписки sub.ID и заполняет sub сохраненной строкой.
+// Непустой ifMatch - допустимые версии строки (If-Match); если текущая версия не входит в него,
+// возвращается ErrVersionMismatch.
+func (r *subscriptionRepo) Update(ctx context.Context, sub *models.Subscription, ifMatch []int64) error {
+    tx, err := beginTx(ctx, r.db)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    before, err := lockSnapshot(ctx, tx, sub.ID, false)
+    if err != nil {
+        return err
+    }
+
     query := `
         UPDATE subscriptions 
-        SET service_name = COALESCE($1, service_name),
-            price = COALESCE($2, price),
-            end_date = COALESCE($3, end_date),
+        SET service_id = $1,
+            service_name = $2,
+            price = $3,
+            currency = $4,
+            billing_period = $5,
+            billing_anchor_day = $6,
+            user_id = $7,
+            start_date = $8,
+            end_date = $9,
+            version = version + 1,
             updated_at = CURRENT_TIMESTAMP
-        WHERE id = $4
+        WHERE id = $10 AND ($11::int[] IS NULL OR version = ANY($11))
+        RETURNING ` + subscriptionColumns + `, ` + snapshotColumn + `
     `
 
-    result, err := r.db.ExecContext(ctx, query, req.ServiceName, req.Price, req.EndDate, id)
+    var after []byte
+    updated, err := scanSubscription(tx.QueryRowContext(
+        ctx,
+        query,
+        sub.ServiceID,
+        sub.ServiceName,
+        sub.Price,
+        sub.Currency,
+        sub.BillingPeriod,
+        sub.BillingAnchorDay,
+        sub.UserID,
+        sub.StartDate,
+        sub.EndDate,
+        sub.ID,
+        pq.Int64Array(ifMatch),
+    ), &after)
+    if err == sql.ErrNoRows {
+        return ErrVersionMismatch
+    }
     if err != nil {
-        log.Printf("Error updating subscription %s: %v", id, err)
+        if isDuplicateError(err) {
+            return ErrSubscriptionExists
+        }
+        log.Printf("Error updating subscription %s: %v", sub.ID, err)
         return fmt.Errorf("failed to update subscription: %w", err)
     }
 
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return fmt.Errorf("failed to get rows affected: %w", err)
+    if err := recordEvent(ctx, tx, sub.ID, models.EventUpdated, before, after); err != nil {
+        return err
     }
 
-    if rows == 0 {
-        return fmt.Errorf("subscription not found")
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit subscription: %w", err)
     }
 
-    log.Printf("Updated subscription with ID: %s", id)
+    if err := r.attachPrices(ctx, []*models.Subscription{updated}, nil); err != nil {
+        return err
+    }
+    *sub = *updated
+
+    log.Printf("Updated subscription with ID: %s", sub.ID)
     return nil
 }
 
-func (r *subscriptionRepo) Delete(ctx context.Context, id uuid.UUID) error {
-    query := `DELETE FROM subscriptions WHERE id = $1`
+// Delete помечает подписку удаленной моментом deletedAt; окончательно строка удаляется через Purge
+func (r *subscriptionRepo) Delete(ctx context.Context, id uuid.UUID, deletedAt time.Time, ifMatch []int64) error {
+    tx, err := beginTx(ctx, r.db)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
 
-    result, err := r.db.ExecContext(ctx, query, id)
+    before, err := lockSnapshot(ctx, tx, id, false)
+    if err != nil {
+        return err
+    }
+
+    query := `
+        UPDATE subscriptions
+        SET deleted_at = $3,
+            version = version + 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND ($2::int[] IS NULL OR version = ANY($2))
+        RETURNING ` + snapshotColumn + `
+    `
+
+    var after []byte
+    err = tx.QueryRowContext(ctx, query, id, pq.Int64Array(ifMatch), deletedAt).Scan(&after)
+    if err == sql.ErrNoRows {
+        return ErrVersionMismatch
+    }
     if err != nil {
         log.Printf("Error deleting subscription %s: %v", id, err)
         return fmt.Errorf("failed to delete subscription: %w", err)
     }
 
-    rows, err := result.RowsAffected()
-    if err != nil {
-        return fmt.Errorf("failed to get rows affected: %w", err)
+    if err := recordEvent(ctx, tx, id, models.EventDeleted, before, after); err != nil {
+        return err
     }
 
-    if rows == 0 {
-        return fmt.Errorf("subscription not found")
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit subscription: %w", err)
     }
 
     log.Printf("Deleted subscription with ID: %s", id)
     return nil
 }
 
-func (r *subscriptionRepo) List(ctx context.Context, userID *uuid.UUID, serviceName *string) ([]*models.Subscription, error) {
+func (r *subscriptionRepo) Restore(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+    tx, err := beginTx(ctx, r.db)
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    before, err := lockSnapshot(ctx, tx, id, true)
+    if err != nil {
+        return nil, err
+    }
+
     query := `
-        SELECT id, service_name, price, user_id, start_date, end_date, created_at, updated_at
-        FROM subscriptions 
-        WHERE 1=1
+        UPDATE subscriptions
+        SET deleted_at = NULL,
+            version = version + 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING ` + subscriptionColumns + `, ` + snapshotColumn
+
+    var after []byte
+    sub, err := scanSubscription(tx.QueryRowContext(ctx, query, id), &after)
+    if err != nil {
+        if isDuplicateError(err) {
+            return nil, ErrRestoreConflict
+        }
+        log.Printf("Error restoring subscription %s: %v", id, err)
+        return nil, fmt.Errorf("failed to restore subscription: %w", err)
+    }
+
+    if err := recordEvent(ctx, tx, id, models.EventRestored, before, after); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit subscription: %w", err)
+    }
+
+    if err := r.attachPrices(ctx, []*models.Subscription{sub}, nil); err != nil {
+        return nil, fmt.Errorf("failed to restore subscription: %w", err)
+    }
+
+    log.Printf("Restored subscription with ID: %s", id)
+    return sub, nil
+}
+
+// Purge окончательно удаляет подписки, помеченные удаленными раньше deletedBefore.
+// Последний снимок каждой удаленной строки остается в журнале изменений, а ее версии и изменения цены -
+// в срезах as_of до очистки.
+func (r *subscriptionRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+    query := `
+        WITH purged AS (
+            DELETE FROM subscriptions
+            WHERE deleted_at IS NOT NULL AND deleted_at < $1
+            RETURNING id, ` + snapshotColumn + ` AS snapshot
+        )
+        INSERT INTO subscription_events (subscription_id, event_type, before, actor, request_id)
+        SELECT id, $2, snapshot, $3, $4 FROM purged
     `
-    args := []interface{}{}
+
+    result, err := r.conn(ctx).ExecContext(
+        ctx,
+        query,
+        deletedBefore,
+        models.EventPurged,
+        nullString(requestctx.Actor(ctx)),
+        nullString(requestctx.RequestID(ctx)),
+    )
+    if err != nil {
+        log.Printf("Error purging deleted subscriptions: %v", err)
+        return 0, fmt.Errorf("failed to purge subscriptions: %w", err)
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return 0, fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    log.Printf("Purged %d deleted subscriptions", rows)
+    return rows, nil
+}
+
+// listConditions возвращает FROM и WHERE запроса списка подписок по фильтру вместе с аргументами.
+// relevance - выражение оценки нечеткого поиска для выборки и сортировки по релевантности.
+func listConditions(filter *models.SubscriptionFilter) (query string, args []interface{}, relevance string) {
+    args = []interface{}{}
     argPos := 1
 
-    if userID != nil {
+    // Нечеткий поиск по названию: совпадение с любым словом названия с учетом опечаток
+    // или вхождение подстроки; оба условия обслуживаются индексом pg_trgm
+    relevance = "0::real"
+    if filter.Query != "" {
+        q := models.NormalizeServiceName(filter.Query)
+        relevance = "word_similarity($1, service_name_normalized)"
+        args = append(args, q, "%"+likeEscaper.Replace(q)+"%")
+        argPos += 2
+    }
+
+    query = `
+        FROM ` + subscriptionsAsOf(filter.AsOf) + ` 
+        WHERE 1=1
+    `
+
+    if !filter.IncludeDeleted {
+        query += " AND deleted_at IS NULL"
+    }
+
+    if filter.Query != "" {
+        query += " AND ($1 <% service_name_normalized OR service_name_normalized LIKE $2)"
+    }
+
+    if filter.UserID != nil {
         query += fmt.Sprintf(" AND user_id = $%d", argPos)
-        args = append(args, *userID)
+        args = append(args, *filter.UserID)
+        argPos++
+    }
+
+    if len(filter.ServiceNames) > 0 {
+        names := make([]string, len(filter.ServiceNames))
+        for i, name := range filter.ServiceNames {
+            names[i] = models.NormalizeServiceName(name)
+        }
+        query += fmt.Sprintf(" AND service_name_normalized = ANY($%d)", argPos)
+        args = append(args, pq.Array(names))
+        argPos++
+    }
+
+
+    if filter.ActiveOn != nil {
+        query += fmt.Sprintf(" AND start_date <= $%d AND (end_date IS NULL OR end_date >= $%d)", argPos, argPos)
+        args = append(args, *filter.ActiveOn)
+        argPos++
+    }
+
+    if filter.StartedAfter != nil {
+        query += fmt.Sprintf(" AND start_date > $%d", argPos)
+        args = append(args, *filter.StartedAfter)
         argPos++
     }
 
-    if serviceName != nil {
-        query += fmt.Sprintf(" AND service_name = $%d", argPos)
-        args = append(args, *serviceName)
+    if filter.StartedBefore != nil {
+        query += fmt.Sprintf(" AND start_date < $%d", argPos)
+        args = append(args, *filter.StartedBefore)
         argPos++
     }
 
-    query += " ORDER BY created_at DESC"
+    if filter.Ended != nil {
+        if *filter.Ended {
+            query += " AND end_date IS NOT NULL AND end_date < CURRENT_DATE"
+        } else {
+            query += " AND (end_date IS NULL OR end_date >= CURRENT_DATE)"
+        }
+    }
+
+    if filter.MinPrice != nil {
+        query += fmt.Sprintf(" AND price >= $%d", argPos)
+        args = append(args, *filter.MinPrice)
+        argPos++
+    }
+
+    if filter.MaxPrice != nil {
+        query += fmt.Sprintf(" AND price <= $%d", argPos)
+        args = append(args, *filter.MaxPrice)
+        argPos++
+    }
+
+    return query, args, relevance
+}
+
+func (r *subscriptionRepo) List(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest) (*models.SubscriptionPage, error) {
+    conditions, args, relevance := listConditions(filter)
+    argPos := len(args) + 1
+
+    query := `
+        SELECT ` + subscriptionColumns + `, ` + relevance + ` AS relevance` + conditions
+
+    sqlType, ok := sortColumns[page.Sort]
+    if !ok {
+        return nil, fmt.Errorf("unsupported sort field: %s", page.Sort)
+    }
+
+    sortExpr := page.Sort
+    if page.Sort == models.SortRelevance {
+        sortExpr = relevance
+    }
+
+    direction, comparison := "DESC", "<"
+    if page.Order == models.OrderAsc {
+        direction, comparison = "ASC", ">"
+    }
+
+    if page.Cursor != "" {
+        cursor, err := decodeCursor(page)
+        if err != nil {
+            return nil, err
+        }
+        query += fmt.Sprintf(" AND (%s, id) %s ($%d::%s, $%d)", sortExpr, comparison, argPos, sqlType, argPos+1)
+        args = append(args, cursor.Value, cursor.ID)
+        argPos += 2
+    }
+
+    // Запрашиваем на одну строку больше, чтобы узнать, есть ли следующая страница
+    query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", sortExpr, direction, direction, argPos)
+    args = append(args, page.Limit+1)
 
-    rows, err := r.db.QueryContext(ctx, query, args...)
+    rows, err := r.conn(ctx).QueryContext(ctx, query, args...)
     if err != nil {
         log.Printf("Error listing subscriptions: %v", err)
         return nil, fmt.Errorf("failed to list subscriptions: %w", err)
     }
     defer rows.Close()
 
-    var subscriptions []*models.Subscription
+    result := &models.SubscriptionPage{Items: []*models.Subscription{}}
+    var scores []float64
     for rows.Next() {
-        var sub models.Subscription
-        err := rows.Scan(
-            &sub.ID,
-            &sub.ServiceName,
-            &sub.Price,
-            &sub.UserID,
-            &sub.StartDate,
-            &sub.EndDate,
-            &sub.CreatedAt,
-            &sub.UpdatedAt,
-        )
+        var score float64
+        sub, err := scanSubscription(rows, &score)
         if err != nil {
             return nil, fmt.Errorf("failed to scan subscription: %w", err)
         }
-        subscriptions = append(subscriptions, &sub)
+        result.Items = append(result.Items, sub)
+        scores = append(scores, score)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to list subscriptions: %w", err)
     }
 
-    log.Printf("Listed %d subscriptions", len(subscriptions))
-    return subscriptions, nil
+    if len(result.Items) > page.Limit {
+        result.Items = result.Items[:page.Limit]
+        result.HasMore = true
+
+        last := result.Items[len(result.Items)-1]
+        value := sortValue(page.Sort, last)
+        if page.Sort == models.SortRelevance {
+            value = strconv.FormatFloat(scores[len(result.Items)-1], 'g', -1, 64)
+        }
+        result.NextCursor = encodeCursor(page, value, last.ID)
+    }
+
+    if err := r.attachPrices(ctx, result.Items, filter.AsOf); err != nil {
+        return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+    }
+
+    log.Printf("Listed %d subscriptions", len(result.Items))
+    return result, nil
+}
+
+// ExportList передает fn все подписки, подходящие под фильтр, в порядке page.Sort и page.Order; Limit и Cursor
+// не учитываются. Строки читаются курсором, поэтому выгрузка не держит в памяти всю выборку.
+func (r *subscriptionRepo) ExportList(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest, fn func(sub *models.Subscription) error) error {
+    conditions, args, relevance := listConditions(filter)
+
+    if _, ok := sortColumns[page.Sort]; !ok {
+        return fmt.Errorf("unsupported sort field: %s", page.Sort)
+    }
+
+    sortExpr := page.Sort
+    if page.Sort == models.SortRelevance {
+        sortExpr = relevance
+    }
+
+    direction := "DESC"
+    if page.Order == models.OrderAsc {
+        direction = "ASC"
+    }
+
+    query := `
+        SELECT ` + subscriptionColumns + conditions + fmt.Sprintf(" ORDER BY %s %s, id %s", sortExpr, direction, direction)
+
+    conn, err := r.streamConn(ctx)
+    if err != nil {
+        return err
+    }
+    rows, err := conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        log.Printf("Error exporting subscriptions: %v", err)
+        return fmt.Errorf("failed to export subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    count := 0
+    err = r.eachSubscription(ctx, rows, filter.AsOf, func(sub *models.Subscription) error {
+        count++
+        return fn(sub)
+    })
+    if err != nil {
+        return fmt.Errorf("failed to export subscriptions: %w", err)
+    }
+
+    log.Printf("Exported %d subscriptions", count)
+    return nil
 }
 
 func (r *subscriptionRepo) GetSummary(ctx context.Context, req *models.SummaryRequest) (*models.SubscriptionSummary, error) {
-    query := `SELECT COALESCE(SUM(price), 0) as total_cost FROM subscriptions WHERE 1=1`
+    from, to := summaryWindow(req)
+
+    subscriptions, err := r.listForPeriod(ctx, req, from, to)
+    if err != nil {
+        log.Printf("Error calculating subscription summary: %v", err)
+        return nil, fmt.Errorf("failed to calculate summary: %w", err)
+    }
+
+    rates, err := loadRateTable(ctx, r.db, req.Currency)
+    if err != nil {
+        return nil, fmt.Errorf("failed to calculate summary: %w", err)
+    }
+
+    now := reportTime(req)
+    summary := &models.SubscriptionSummary{Currency: rates.currency()}
+    for _, sub := range subscriptions {
+        item, err := subscriptionCost(sub, from, to, now, rates)
+        if err != nil {
+            return nil, fmt.Errorf("failed to calculate summary: %w", err)
+        }
+        if item == nil {
+            continue
+        }
+
+        summary.TotalCost += item.Cost
+        summary.AmortizedCost += item.AmortizedCost
+        summary.Subscriptions = append(summary.Subscriptions, *item)
+    }
+
+    if req.GroupBy != "" {
+        summary.Groups = groupSummary(summary.Subscriptions, req.GroupBy)
+        summary.Subscriptions = nil
+    }
+
+    log.Printf("Calculated summary: total cost = %s", summary.TotalCost)
+    return summary, nil
+}
+
+// ExportSummary передает fn стоимость каждой подписки за период, как в GetSummary без группировки.
+// Подписки читаются курсором и не накапливаются в памяти.
+func (r *subscriptionRepo) ExportSummary(ctx context.Context, req *models.SummaryRequest, fn func(item *models.SubscriptionCost) error) error {
+    from, to := summaryWindow(req)
+
+    rates, err := loadRateTable(ctx, r.db, req.Currency)
+    if err != nil {
+        return fmt.Errorf("failed to export summary: %w", err)
+    }
+
+    now := reportTime(req)
+    err = r.eachForPeriod(ctx, req, from, to, func(sub *models.Subscription) error {
+        item, err := subscriptionCost(sub, from, to, now, rates)
+        if err != nil || item == nil {
+            return err
+        }
+        return fn(item)
+    })
+    if err != nil {
+        log.Printf("Error exporting subscription summary: %v", err)
+        return fmt.Errorf("failed to export summary: %w", err)
+    }
+    return nil
+}
+
+// subscriptionCost считает стоимость подписки за период [from, to]; nil, если в периоде она не действовала
+func subscriptionCost(sub *models.Subscription, from, to *time.Time, now time.Time, rates *rateTable) (*models.SubscriptionCost, error) {
+    first, last := activeMonths(sub, from, to, now)
+    if last < first {
+        return nil, nil
+    }
+
+    costs, err := monthlyCosts(sub, first, last, rates)
+    if err != nil {
+        return nil, err
+    }
+
+    item := &models.SubscriptionCost{Subscription: *sub, Months: len(costs)}
+    for _, cost := range costs {
+        item.Charges += cost.charges
+        item.Cost += cost.charged
+        item.AmortizedCost += cost.amortized
+    }
+    return item, nil
+}
+
+func (r *subscriptionRepo) GetTimeSeries(ctx context.Context, req *models.TimeSeriesRequest) (*models.TimeSeries, error) {
+    from, to := summaryWindow(&req.SummaryRequest)
+    if from == nil || to == nil {
+        return nil, fmt.Errorf("time series requires both start_date and end_date")
+    }
+
+    subscriptions, err := r.listForPeriod(ctx, &req.SummaryRequest, from, to)
+    if err != nil {
+        log.Printf("Error calculating subscription time series: %v", err)
+        return nil, fmt.Errorf("failed to calculate time series: %w", err)
+    }
+
+    rates, err := loadRateTable(ctx, r.db, req.Currency)
+    if err != nil {
+        return nil, fmt.Errorf("failed to calculate time series: %w", err)
+    }
+
+    size := bucketSize(req.Granularity)
+    firstBucket := monthIndex(*from) / size
+    lastBucket := monthIndex(*to) / size
+
+    buckets := make([]models.TimeSeriesBucket, lastBucket-firstBucket+1)
+    serviceCosts := make([]map[string]models.Money, len(buckets))
+    for i := range buckets {
+        start := monthFromIndex((firstBucket + i) * size)
+        buckets[i].PeriodStart = start
+        buckets[i].PeriodEnd = start.AddDate(0, size, -1)
+        buckets[i].Services = []models.ServiceCost{}
+        serviceCosts[i] = map[string]models.Money{}
+    }
+
+    now := reportTime(&req.SummaryRequest)
+    for _, sub := range subscriptions {
+        first, last := activeMonths(sub, from, to, now)
+        if last < first {
+            continue
+        }
+
+        costs, err := monthlyCosts(sub, first, last, rates)
+        if err != nil {
+            return nil, fmt.Errorf("failed to calculate time series: %w", err)
+        }
+
+        lastSeen := -1
+        for _, cost := range costs {
+            i := cost.month/size - firstBucket
+            buckets[i].TotalCost += cost.charged
+            buckets[i].AmortizedCost += cost.amortized
+            serviceCosts[i][sub.ServiceName] += cost.charged
+            if i != lastSeen {
+                buckets[i].ActiveSubscriptions++
+                lastSeen = i
+            }
+        }
+    }
+
+    for i := range buckets {
+        for name, cost := range serviceCosts[i] {
+            buckets[i].Services = append(buckets[i].Services, models.ServiceCost{ServiceName: name, TotalCost: cost})
+        }
+        sort.Slice(buckets[i].Services, func(a, b int) bool {
+            return buckets[i].Services[a].ServiceName < buckets[i].Services[b].ServiceName
+        })
+    }
+
+    log.Printf("Calculated time series: %d buckets by %s", len(buckets), req.Granularity)
+    return &models.TimeSeries{Granularity: req.Granularity, Currency: rates.currency(), Buckets: buckets}, nil
+}
+
+func (r *subscriptionRepo) ListUpcomingCharges(ctx context.Context, req *models.UpcomingRequest) (*models.UpcomingCharges, error) {
+    from, to := upcomingWindow(time.Now(), req.Days)
+
+    filter := &models.SummaryRequest{UserID: req.UserID, ServiceName: req.ServiceName}
+    subscriptions, err := r.listForPeriod(ctx, filter, &from, &to)
+    if err != nil {
+        log.Printf("Error listing upcoming charges: %v", err)
+        return nil, fmt.Errorf("failed to list upcoming charges: %w", err)
+    }
+
+    result := &models.UpcomingCharges{From: from, To: to, Charges: []models.UpcomingCharge{}}
+    for _, sub := range subscriptions {
+        for _, date := range sub.ChargeDates(from, to) {
+            result.Charges = append(result.Charges, models.UpcomingCharge{
+                SubscriptionID: sub.ID,
+                ServiceName:    sub.ServiceName,
+                UserID:         sub.UserID,
+                Date:           date,
+                Amount:         sub.PriceOn(date),
+                Currency:       sub.Currency,
+            })
+        }
+    }
+
+    sort.SliceStable(result.Charges, func(a, b int) bool {
+        return result.Charges[a].Date.Before(result.Charges[b].Date)
+    })
+
+    log.Printf("Listed %d upcoming charges for %d days", len(result.Charges), req.Days)
+    return result, nil
+}
+
+// upcomingWindow возвращает первый и последний день окна из days дней, начиная с сегодняшнего.
+// ChargeDates включает обе границы, поэтому последний день - from + days - 1.
+func upcomingWindow(now time.Time, days int) (from, to time.Time) {
+    now = now.UTC()
+    from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    return from, from.AddDate(0, 0, days-1)
+}
+
+// monthCost - стоимость подписки за один месяц
+type monthCost struct {
+    month     int
+    charges   int
+    charged   models.Money
+    amortized models.Money
+}
+
+// monthlyCosts раскладывает стоимость подписки по месяцам [first, last]: фактические списания
+// попадают в месяц даты списания, амортизированная стоимость делится поровну между месяцами
+func monthlyCosts(sub *models.Subscription, first, last int, rates *rateTable) ([]monthCost, error) {
+    costs := make([]monthCost, last-first+1)
+    for i := range costs {
+        costs[i].month = first + i
+
+        // Амортизированная стоимость месяца считается по цене, действующей на его начало
+        month := monthFromIndex(first + i)
+        monthly := sub.BillingPeriod.MonthlyEquivalent(sub.PriceOn(month))
+        amortized, err := rates.convert(monthly, sub.Currency, month)
+        if err != nil {
+            return nil, err
+        }
+        costs[i].amortized = amortized
+    }
+
+    for _, date := range sub.ChargeDates(monthFromIndex(first), monthFromIndex(last+1).AddDate(0, 0, -1)) {
+        price, err := rates.convert(sub.PriceOn(date), sub.Currency, date)
+        if err != nil {
+            return nil, err
+        }
+
+        i := monthIndex(date) - first
+        costs[i].charges++
+        costs[i].charged += price
+    }
+
+    return costs, nil
+}
+
+// groupSummary агрегирует стоимость подписок по ключу группировки, самые дорогие группы идут первыми
+func groupSummary(costs []models.SubscriptionCost, groupBy string) []models.SummaryGroup {
+    index := map[string]int{}
+    groups := []models.SummaryGroup{}
+    for _, item := range costs {
+        key := item.ServiceName
+        if groupBy == models.GroupByUserID {
+            key = item.UserID.String()
+        }
+
+        i, ok := index[key]
+        if !ok {
+            i = len(groups)
+            index[key] = i
+            groups = append(groups, models.SummaryGroup{Key: key})
+        }
+        groups[i].TotalCost += item.Cost
+        groups[i].SubscriptionCount++
+    }
+
+    sort.Slice(groups, func(a, b int) bool {
+        if groups[a].TotalCost != groups[b].TotalCost {
+            return groups[a].TotalCost > groups[b].TotalCost
+        }
+        return groups[a].Key < groups[b].Key
+    })
+    return groups
+}
+
+// listForPeriod возвращает подписки, пересекающиеся с периодом [from, to] и подходящие под фильтры
+func (r *subscriptionRepo) listForPeriod(ctx context.Context, req *models.SummaryRequest, from, to *time.Time) ([]*models.Subscription, error) {
+    var subscriptions []*models.Subscription
+    err := r.eachForPeriod(ctx, req, from, to, func(sub *models.Subscription) error {
+        subscriptions = append(subscriptions, sub)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return subscriptions, nil
+}
+
+// eachForPeriod передает fn подписки, пересекающиеся с периодом [from, to] и подходящие под фильтры
+func (r *subscriptionRepo) eachForPeriod(ctx context.Context, req *models.SummaryRequest, from, to *time.Time, fn func(sub *models.Subscription) error) error {
+    query := `
+        SELECT ` + subscriptionColumns + `
+        FROM ` + subscriptionsAsOf(req.AsOf) + `
+        WHERE deleted_at IS NULL
+    `
     args := []interface{}{}
     argPos := 1
 
-    if req.StartDate != nil && req.EndDate != nil {
+    if from != nil && to != nil {
         query += fmt.Sprintf(" AND start_date <= $%d AND (end_date IS NULL OR end_date >= $%d)", argPos, argPos+1)
-        args = append(args, *req.EndDate, *req.StartDate)
+        args = append(args, *to, *from)
         argPos += 2
-    } else if req.StartDate != nil {
+    } else if from != nil {
         query += fmt.Sprintf(" AND (end_date IS NULL OR end_date >= $%d)", argPos)
-        args = append(args, *req.StartDate)
+        args = append(args, *from)
         argPos++
-    } else if req.EndDate != nil {
+    } else if to != nil {
         query += fmt.Sprintf(" AND start_date <= $%d", argPos)
-        args = append(args, *req.EndDate)
+        args = append(args, *to)
         argPos++
     }
 
@@ -268,21 +1305,126 @@ func (r *subscriptionRepo) GetSummary(ctx context.Context, req *models.SummaryRe
     }
 
     if req.ServiceName != nil {
-        query += fmt.Sprintf(" AND service_name = $%d", argPos)
-        args = append(args, *req.ServiceName)
+        query += fmt.Sprintf(" AND service_name_normalized = $%d", argPos)
+        args = append(args, models.NormalizeServiceName(*req.ServiceName))
     }
 
-    var totalCost float64
-    err := r.db.QueryRowContext(ctx, query, args...).Scan(&totalCost)
+    query += " ORDER BY start_date, id"
+
+    conn, err := r.streamConn(ctx)
     if err != nil {
-        log.Printf("Error calculating subscription summary: %v", err)
-        return nil, fmt.Errorf("failed to calculate summary: %w", err)
+        return err
+    }
+    rows, err := conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        return err
     }
+    defer rows.Close()
 
-    summary := &models.SubscriptionSummary{
-        TotalCost: totalCost,
+    return r.eachSubscription(ctx, rows, req.AsOf, fn)
+}
+
+// eachSubscription читает подписки из rows и передает их fn по одной. Изменения цены загружаются пачками
+// по streamChunkSize строк, чтобы не держать в памяти всю выборку и не делать запрос на каждую строку.
+// Запрос изменений идет, пока rows еще открыт, поэтому rows открывается через streamConn, вне транзакции.
+func (r *subscriptionRepo) eachSubscription(ctx context.Context, rows *sql.Rows, asOf *time.Time, fn func(sub *models.Subscription) error) error {
+    chunk := make([]*models.Subscription, 0, streamChunkSize)
+    flush := func() error {
+        if err := r.attachPrices(ctx, chunk, asOf); err != nil {
+            return err
+        }
+        for _, sub := range chunk {
+            if err := fn(sub); err != nil {
+                return err
+            }
+        }
+        chunk = chunk[:0]
+        return nil
     }
 
-    log.Printf("Calculated summary: total cost = %.2f", totalCost)
-    return summary, nil
-}
\ No newline at end of file
+    for rows.Next() {
+        sub, err := scanSubscription(rows)
+        if err != nil {
+            return fmt.Errorf("failed to scan subscription: %w", err)
+        }
+
+        chunk = append(chunk, sub)
+        if len(chunk) == streamChunkSize {
+            if err := flush(); err != nil {
+                return err
+            }
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    return flush()
+}
+
+// reportTime - момент, на который строится отчет: as_of запроса или текущее время
+func reportTime(req *models.SummaryRequest) time.Time {
+    if req.AsOf != nil {
+        return req.AsOf.UTC()
+    }
+    return time.Now().UTC()
+}
+
+// summaryWindow выравнивает границы периода по месяцам: оплата считается помесячно
+func summaryWindow(req *models.SummaryRequest) (from, to *time.Time) {
+    if req.StartDate != nil {
+        start := monthStart(*req.StartDate)
+        from = &start
+    }
+    if req.EndDate != nil {
+        end := monthStart(*req.EndDate).AddDate(0, 1, -1)
+        to = &end
+    }
+    return from, to
+}
+
+func bucketSize(granularity string) int {
+    switch granularity {
+    case models.GranularityQuarter:
+        return 3
+    case models.GranularityYear:
+        return 12
+    default:
+        return 1
+    }
+}
+
+// activeMonths возвращает индексы первого и последнего оплачиваемых месяцев подписки внутри периода [from, to].
+// Бессрочная подписка ограничивается концом периода, а без него - текущим месяцем.
+func activeMonths(sub *models.Subscription, from, to *time.Time, now time.Time) (first, last int) {
+    first = monthIndex(sub.StartDate)
+    if from != nil && monthIndex(*from) > first {
+        first = monthIndex(*from)
+    }
+
+    switch {
+    case sub.EndDate != nil:
+        last = monthIndex(*sub.EndDate)
+    case to != nil:
+        last = monthIndex(*to)
+    default:
+        last = monthIndex(now)
+    }
+    if to != nil && monthIndex(*to) < last {
+        last = monthIndex(*to)
+    }
+
+    return first, last
+}
+
+func monthIndex(t time.Time) int {
+    return t.Year()*12 + int(t.Month()) - 1
+}
+
+func monthFromIndex(i int) time.Time {
+    return time.Date(i/12, time.Month(i%12+1), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func monthStart(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+    "context"
+    "database/sql/driver"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+    return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestUpcomingWindow(t *testing.T) {
+    now := time.Date(2024, time.March, 10, 15, 30, 0, 0, time.UTC)
+    // Семь еженедельных подписок, начатых в разные дни недели, дают ровно одно списание в каждый день
+    subs := make([]*models.Subscription, 0, 7)
+    for day := 10; day < 17; day++ {
+        subs = append(subs, &models.Subscription{BillingPeriod: models.BillingWeekly, StartDate: date(2024, time.March, day)})
+    }
+
+    tests := []struct {
+        name        string
+        days        int
+        wantTo      time.Time
+        wantCharges int
+    }{
+        {name: "today only", days: 1, wantTo: date(2024, time.March, 10), wantCharges: 1},
+        {name: "one week", days: 7, wantTo: date(2024, time.March, 16), wantCharges: 7},
+        {name: "thirty days", days: 30, wantTo: date(2024, time.April, 8), wantCharges: 30},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            from, to := upcomingWindow(now, tt.days)
+            if !from.Equal(date(2024, time.March, 10)) {
+                t.Fatalf("upcomingWindow() from = %s, want 2024-03-10", from)
+            }
+            if !to.Equal(tt.wantTo) {
+                t.Fatalf("upcomingWindow() to = %s, want %s", to, tt.wantTo)
+            }
+
+            charges := 0
+            for _, sub := range subs {
+                charges += len(sub.ChargeDates(from, to))
+            }
+            if charges != tt.wantCharges {
+                t.Fatalf("charges in %d-day window = %d, want %d", tt.days, charges, tt.wantCharges)
+            }
+        })
+    }
+}
+
+// subscriptionStep - ответ на запрос, выбирающий subscriptionColumns и затем колонки extra
+func subscriptionStep(query string, sub *models.Subscription, extra ...driver.Value) fakeStep {
+    columns := strings.Split(subscriptionColumns, ", ")
+    for i := range extra {
+        columns = append(columns, fmt.Sprintf("extra%d", i))
+    }
+
+    row := []driver.Value{
+        sub.ID.String(), nil, sub.ServiceName, sub.Price.String(), sub.Currency, string(sub.BillingPeriod), nil,
+        sub.UserID.String(), sub.StartDate, nil, sub.CreatedAt, sub.UpdatedAt, nil, int64(sub.Version),
+    }
+    return fakeStep{query: query, columns: columns, rows: [][]driver.Value{append(row, extra...)}}
+}
+
+func TestUpsert(t *testing.T) {
+    stored := &models.Subscription{
+        ID:            uuid.New(),
+        ServiceName:   "Netflix",
+        Price:         79900,
+        Currency:      "RUB",
+        BillingPeriod: models.BillingMonthly,
+        UserID:        uuid.New(),
+        StartDate:     date(2024, time.January, 1),
+        CreatedAt:     date(2024, time.January, 1),
+        UpdatedAt:     date(2024, time.January, 1),
+        Version:       3,
+    }
+    snapshot := []byte(`{}`)
+    noPrices := fakeStep{query: "FROM subscription_prices", columns: []string{"subscription_id", "effective_from", "price", "created_at"}}
+
+    tests := []struct {
+        name        string
+        steps       []fakeStep
+        wantCreated bool
+        wantVersion int
+    }{
+        {
+            // ON CONFLICT ... WHERE не возвращает строку, если данные не изменились
+            name: "unchanged",
+            steps: []fakeStep{
+                subscriptionStep("FOR UPDATE", stored, snapshot),
+                {query: "ON CONFLICT", columns: make([]string, 17)},
+                noPrices,
+            },
+            wantVersion: 3,
+        },
+        {
+            name: "changed",
+            steps: []fakeStep{
+                subscriptionStep("FOR UPDATE", stored, snapshot),
+                subscriptionStep("ON CONFLICT", &models.Subscription{
+                    ID: stored.ID, ServiceName: stored.ServiceName, Price: 89900, Currency: "RUB", BillingPeriod: models.BillingMonthly,
+                    UserID: stored.UserID, StartDate: stored.StartDate, Version: 4,
+                }, snapshot, false),
+                {query: "INSERT INTO subscription_events"},
+                noPrices,
+            },
+            wantVersion: 4,
+        },
+        {
+            name: "created",
+            steps: []fakeStep{
+                {query: "FOR UPDATE", columns: make([]string, 15)},
+                subscriptionStep("ON CONFLICT", stored, snapshot, true),
+                {query: "INSERT INTO subscription_events"},
+                noPrices,
+            },
+            wantCreated: true,
+            wantVersion: 3,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db, fake := newFakeDB(t, tt.steps...)
+            repo := NewSubscriptionRepository(db)
+
+            sub := &models.Subscription{
+                ServiceName:   "netflix",
+                Price:         79900,
+                Currency:      "RUB",
+                BillingPeriod: models.BillingMonthly,
+                UserID:        stored.UserID,
+                StartDate:     stored.StartDate,
+            }
+            created, err := repo.Upsert(context.Background(), sub)
+            if err != nil {
+                t.Fatalf("Upsert() error = %v, want nil", err)
+            }
+            if created != tt.wantCreated {
+                t.Fatalf("Upsert() created = %t, want %t", created, tt.wantCreated)
+            }
+            if sub.ID != stored.ID || sub.Version != tt.wantVersion {
+                t.Fatalf("Upsert() subscription = %s v%d, want %s v%d", sub.ID, sub.Version, stored.ID, tt.wantVersion)
+            }
+            if last := fake.log[len(fake.log)-2]; last != "COMMIT" {
+                t.Fatalf("statement before loading prices = %q, want COMMIT", last)
+            }
+        })
+    }
+}
+
+func TestCreateMany(t *testing.T) {
+    userID := uuid.New()
+    subs := []*models.Subscription{
+        {ServiceName: "Netflix", Price: 79900, Currency: "RUB", BillingPeriod: models.BillingMonthly, UserID: userID, StartDate: date(2024, time.January, 1)},
+        {ServiceName: " spotify ", Price: 29900, Currency: "RUB", BillingPeriod: models.BillingMonthly, UserID: userID, StartDate: date(2024, time.February, 1)},
+    }
+
+    // Первую строку отсек ON CONFLICT; вторая вернулась с названием и датой в том виде, в каком их хранит база
+    id := uuid.New()
+    createdAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+    db, fake := newFakeDB(t, fakeStep{
+        query:   "INSERT INTO subscription_events",
+        columns: []string{"id", "user_id", "service_name", "start_date", "created_at", "updated_at"},
+        rows:    [][]driver.Value{{id.String(), userID.String(), "spotify", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.FixedZone("", 0)), createdAt, createdAt}},
+    })
+    repo := NewSubscriptionRepository(db)
+
+    created, err := repo.CreateMany(context.Background(), subs)
+    if err != nil {
+        t.Fatalf("CreateMany() error = %v, want nil", err)
+    }
+    if created[0] || !created[1] {
+        t.Fatalf("CreateMany() created = %v, want [false true]", created)
+    }
+    if subs[1].ID != id || !subs[1].CreatedAt.Equal(createdAt) || subs[0].ID != uuid.Nil {
+        t.Fatalf("CreateMany() ids = %s, %s, want %s, %s", subs[0].ID, subs[1].ID, uuid.Nil, id)
+    }
+    if len(fake.log) != 1 {
+        t.Fatalf("CreateMany() ran %d statements, want 1: %q", len(fake.log), fake.log)
+    }
+}
+
+func TestExportInsideTransaction(t *testing.T) {
+    db, _ := newFakeDB(t)
+    repo := NewSubscriptionRepository(db)
+
+    // Курсор занял бы соединение транзакции, и запрос изменений цены не смог бы выполниться
+    err := repo.WithinTx(context.Background(), func(ctx context.Context) error {
+        page := &models.PageRequest{Sort: models.SortCreatedAt, Order: models.OrderDesc}
+        return repo.ExportList(ctx, &models.SubscriptionFilter{}, page, func(sub *models.Subscription) error {
+            return nil
+        })
+    })
+    if err == nil || !strings.Contains(err.Error(), "inside a transaction") {
+        t.Fatalf("ExportList() in transaction error = %v, want a transaction error", err)
+    }
+}
+
+// pricesCascadeOnPurge сообщает, удаляет ли схема после всех миграций изменения цены вместе со строкой подписки
+func pricesCascadeOnPurge(t *testing.T) bool {
+    t.Helper()
+
+    files, err := filepath.Glob("../../migrations/*.sql")
+    if err != nil || len(files) == 0 {
+        t.Fatalf("no migrations found: %v", err)
+    }
+    sort.Strings(files)
+
+    cascade := false
+    for _, file := range files {
+        content, err := os.ReadFile(file)
+        if err != nil {
+            t.Fatalf("read %s: %v", file, err)
+        }
+        if strings.Contains(string(content), "CREATE TABLE subscription_prices") {
+            cascade = strings.Contains(string(content), "ON DELETE CASCADE")
+        }
+        if strings.Contains(string(content), "DROP CONSTRAINT subscription_prices_subscription_id_fkey") {
+            cascade = false
+        }
+    }
+    return cascade
+}
+
+func TestPurgeKeepsPriceChanges(t *testing.T) {
+    sub := &models.Subscription{
+        ID:            uuid.New(),
+        ServiceName:   "Netflix",
+        Price:         100000,
+        Currency:      "RUB",
+        BillingPeriod: models.BillingMonthly,
+        UserID:        uuid.New(),
+        StartDate:     date(2024, time.January, 1),
+        CreatedAt:     date(2024, time.January, 1),
+        UpdatedAt:     date(2024, time.January, 1),
+        Version:       1,
+    }
+    cascade := pricesCascadeOnPurge(t)
+
+    // prices - строки subscription_prices; шаги сценария меняют их так же, как база
+    var prices [][]driver.Value
+    priceColumns := []string{"subscription_id", "effective_from", "price", "created_at"}
+    snapshot := fakeStep{query: "FOR UPDATE", columns: []string{"snapshot"}, rows: [][]driver.Value{{[]byte(`{}`)}}}
+    summarySteps := func() []fakeStep {
+        return []fakeStep{
+            subscriptionStep("FROM subscription_versions", sub),
+            {query: "FROM subscription_prices", columns: priceColumns, respond: func() [][]driver.Value {
+                return append([][]driver.Value(nil), prices...)
+            }},
+        }
+    }
+
+    steps := []fakeStep{
+        snapshot,
+        {query: "INSERT INTO subscription_prices", columns: []string{"created_at"}, respond: func() [][]driver.Value {
+            prices = append(prices, []driver.Value{sub.ID.String(), date(2024, time.April, 1), "2000.00", date(2024, time.March, 1)})
+            return [][]driver.Value{{date(2024, time.March, 1)}}
+        }},
+        {query: "SET version = version + 1"},
+        {query: "INSERT INTO subscription_events"},
+    }
+    steps = append(steps, summarySteps()...)
+    steps = append(steps,
+        snapshot,
+        fakeStep{query: "SET deleted_at", columns: []string{"snapshot"}, rows: [][]driver.Value{{[]byte(`{}`)}}},
+        fakeStep{query: "INSERT INTO subscription_events"},
+        fakeStep{query: "DELETE FROM subscriptions", respond: func() [][]driver.Value {
+            if cascade {
+                prices = nil
+            }
+            return nil
+        }},
+    )
+    steps = append(steps, summarySteps()...)
+
+    db, _ := newFakeDB(t, steps...)
+    repo := NewSubscriptionRepository(db)
+    ctx := context.Background()
+
+    if err := repo.AddPriceChange(ctx, sub.ID, &models.PriceChange{EffectiveFrom: date(2024, time.April, 1), Price: 200000}); err != nil {
+        t.Fatalf("AddPriceChange() error = %v", err)
+    }
+
+    asOf := date(2024, time.June, 30)
+    req := &models.SummaryRequest{StartDate: timePtr(date(2024, time.January, 1)), EndDate: timePtr(date(2024, time.June, 1)), AsOf: &asOf}
+    before, err := repo.GetSummary(ctx, req)
+    if err != nil {
+        t.Fatalf("GetSummary() before purge error = %v", err)
+    }
+
+    if err := repo.Delete(ctx, sub.ID, date(2024, time.July, 1), nil); err != nil {
+        t.Fatalf("Delete() error = %v", err)
+    }
+    if _, err := repo.Purge(ctx, date(2024, time.August, 1)); err != nil {
+        t.Fatalf("Purge() error = %v", err)
+    }
+
+    after, err := repo.GetSummary(ctx, req)
+    if err != nil {
+        t.Fatalf("GetSummary() after purge error = %v", err)
+    }
+
+    // Три месяца по 1000.00 и три по 2000.00 после изменения цены
+    if before.TotalCost != 900000 {
+        t.Fatalf("total cost before purge = %s, want 9000.00", before.TotalCost)
+    }
+    if after.TotalCost != before.TotalCost {
+        t.Fatalf("total cost as of %s after purge = %s, want %s as before purge", asOf.Format("2006-01-02"), after.TotalCost, before.TotalCost)
+    }
+}
+
+func timePtr(t time.Time) *time.Time {
+    return &t
+}
+
+func TestRestoreAttachesPrices(t *testing.T) {
+    sub := &models.Subscription{
+        ID:            uuid.New(),
+        ServiceName:   "Netflix",
+        Price:         100000,
+        Currency:      "RUB",
+        BillingPeriod: models.BillingMonthly,
+        UserID:        uuid.New(),
+        StartDate:     date(2024, time.January, 1),
+        Version:       3,
+    }
+    snapshot := []byte(`{}`)
+
+    db, fake := newFakeDB(t,
+        fakeStep{query: "FOR UPDATE", columns: []string{"snapshot"}, rows: [][]driver.Value{{snapshot}}},
+        subscriptionStep("SET deleted_at = NULL", sub, snapshot),
+        fakeStep{query: "INSERT INTO subscription_events"},
+        fakeStep{
+            query:   "FROM subscription_prices",
+            columns: []string{"subscription_id", "effective_from", "price", "created_at"},
+            rows:    [][]driver.Value{{sub.ID.String(), date(2024, time.April, 1), "2000.00", date(2024, time.March, 1)}},
+        },
+    )
+    repo := NewSubscriptionRepository(db)
+
+    restored, err := repo.Restore(context.Background(), sub.ID)
+    if err != nil {
+        t.Fatalf("Restore() error = %v, want nil", err)
+    }
+    if len(restored.PriceChanges) != 1 || restored.PriceChanges[0].Price != 200000 {
+        t.Fatalf("Restore() price changes = %+v, want the scheduled change to 2000.00", restored.PriceChanges)
+    }
+    if last := fake.log[len(fake.log)-2]; last != "COMMIT" {
+        t.Fatalf("statement before loading prices = %q, want COMMIT", last)
+    }
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "sync/atomic"
+)
+
+// querier - общие методы *sql.DB и *sql.Tx
+type querier interface {
+    ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+    QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// savepointSeq делает имена точек сохранения уникальными
+var savepointSeq uint64
+
+// txn - транзакция репозитория. Если в контексте уже есть транзакция (см. withinTx), txn - точка сохранения
+// внутри нее: Commit освобождает точку, Rollback откатывает только изменения после нее.
+type txn struct {
+    *sql.Tx
+    savepoint string
+    done      bool
+}
+
+func beginTx(ctx context.Context, db *sql.DB) (*txn, error) {
+    outer, ok := ctx.Value(txKey{}).(*sql.Tx)
+    if !ok {
+        tx, err := db.BeginTx(ctx, nil)
+        if err != nil {
+            return nil, err
+        }
+        return &txn{Tx: tx}, nil
+    }
+
+    savepoint := fmt.Sprintf("sp_%d", atomic.AddUint64(&savepointSeq, 1))
+    if _, err := outer.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+        return nil, err
+    }
+    return &txn{Tx: outer, savepoint: savepoint}, nil
+}
+
+func (t *txn) Commit() error {
+    if t.savepoint == "" {
+        return t.Tx.Commit()
+    }
+    if t.done {
+        return sql.ErrTxDone
+    }
+    t.done = true
+    _, err := t.Tx.Exec("RELEASE SAVEPOINT " + t.savepoint)
+    return err
+}
+
+// Rollback после Commit ничего не делает, поэтому его можно откладывать через defer, как и для *sql.Tx
+func (t *txn) Rollback() error {
+    if t.savepoint == "" {
+        return t.Tx.Rollback()
+    }
+    if t.done {
+        return sql.ErrTxDone
+    }
+    t.done = true
+    _, err := t.Tx.Exec("ROLLBACK TO SAVEPOINT " + t.savepoint)
+    return err
+}
+
+// withinTx выполняет fn в одной транзакции: репозитории, получившие переданный fn контекст,
+// пишут и читают через нее. Ошибка fn откатывает все ее изменения.
+func withinTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) error {
+    tx, err := beginTx(ctx, db)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := fn(context.WithValue(ctx, txKey{}, tx.Tx)); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit transaction: %w", err)
+    }
+    return nil
+}
+
+// connFor возвращает транзакцию из контекста или сам пул соединений
+func connFor(ctx context.Context, db *sql.DB) querier {
+    if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+        return tx
+    }
+    return db
+}
@@ -0,0 +1,32 @@
+// Package requestctx передает сведения о запросе (ID запроса, инициатор) через context.Context
+// от HTTP-слоя до репозиториев.
+package requestctx
+
+import "context"
+
+type contextKey int
+
+const (
+    requestIDKey contextKey = iota
+    actorKey
+)
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+    return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID возвращает ID текущего запроса или пустую строку
+func RequestID(ctx context.Context) string {
+    requestID, _ := ctx.Value(requestIDKey).(string)
+    return requestID
+}
+
+func WithActor(ctx context.Context, actor string) context.Context {
+    return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor возвращает инициатора изменения или пустую строку, если он неизвестен
+func Actor(ctx context.Context) string {
+    actor, _ := ctx.Value(actorKey).(string)
+    return actor
+}
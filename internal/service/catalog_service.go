@@ -0,0 +1,64 @@
+package service
+
+import (
+    "context"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+    "subscription-service/internal/repository"
+)
+
+var (
+    ErrServiceNotFound  = repository.ErrServiceNotFound
+    ErrServiceNameTaken = repository.ErrServiceNameTaken
+)
+
+type CatalogService interface {
+    CreateService(ctx context.Context, svc *models.Service) error
+    GetService(ctx context.Context, id uuid.UUID) (*models.Service, error)
+    UpdateService(ctx context.Context, svc *models.Service) error
+    DeleteService(ctx context.Context, id uuid.UUID) error
+    ListServices(ctx context.Context, filter *models.ServiceFilter) ([]*models.Service, error)
+}
+
+type catalogService struct {
+    repo repository.CatalogRepository
+}
+
+func NewCatalogService(repo repository.CatalogRepository) CatalogService {
+    return &catalogService{repo: repo}
+}
+
+func (s *catalogService) CreateService(ctx context.Context, svc *models.Service) error {
+    applyServiceDefaults(svc)
+    return s.repo.Create(ctx, svc)
+}
+
+func (s *catalogService) GetService(ctx context.Context, id uuid.UUID) (*models.Service, error) {
+    return s.repo.GetByID(ctx, id)
+}
+
+func (s *catalogService) UpdateService(ctx context.Context, svc *models.Service) error {
+    applyServiceDefaults(svc)
+    return s.repo.Update(ctx, svc)
+}
+
+func (s *catalogService) DeleteService(ctx context.Context, id uuid.UUID) error {
+    return s.repo.Delete(ctx, id)
+}
+
+func (s *catalogService) ListServices(ctx context.Context, filter *models.ServiceFilter) ([]*models.Service, error) {
+    return s.repo.List(ctx, filter)
+}
+
+func applyServiceDefaults(svc *models.Service) {
+    if svc.Aliases == nil {
+        svc.Aliases = []string{}
+    }
+    if svc.DefaultPrices == nil {
+        svc.DefaultPrices = models.DefaultPrices{}
+    }
+    if svc.Currency == "" {
+        svc.Currency = models.DefaultCurrency
+    }
+}
@@ -0,0 +1,16 @@
+package service
+
+import "subscription-service/internal/repository"
+
+// Базовые ошибки предметной области; обработчики HTTP сопоставляют их с кодами ответа
+var (
+    ErrNotFound           = repository.ErrNotFound
+    ErrConflict           = repository.ErrConflict
+    ErrValidation         = repository.ErrValidation
+    ErrPreconditionFailed = repository.ErrPreconditionFailed
+)
+
+var (
+    ErrSubscriptionNotFound = repository.ErrSubscriptionNotFound
+    ErrSubscriptionExists   = repository.ErrSubscriptionExists
+)
@@ -0,0 +1,94 @@
+package service
+
+import (
+    "context"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+
+    "subscription-service/internal/models"
+    "subscription-service/internal/repository"
+)
+
+// ErrExchangeRateNotFound возвращается, когда для пересчета суммы нет подходящего курса
+var ErrExchangeRateNotFound = repository.ErrExchangeRateNotFound
+
+type ExchangeRateService interface {
+    SaveRates(ctx context.Context, rates []models.ExchangeRate) error
+    ListRates(ctx context.Context, currency *string) ([]models.ExchangeRate, error)
+    LoadFromFile(ctx context.Context, path string) error
+}
+
+type exchangeRateService struct {
+    repo repository.ExchangeRateRepository
+}
+
+func NewExchangeRateService(repo repository.ExchangeRateRepository) ExchangeRateService {
+    return &exchangeRateService{repo: repo}
+}
+
+func (s *exchangeRateService) SaveRates(ctx context.Context, rates []models.ExchangeRate) error {
+    for i, rate := range rates {
+        if rate.Rate.IsZero() {
+            return fmt.Errorf("%w: rate #%d: rate is required", ErrValidation, i+1)
+        }
+        if rate.FromCurrency == rate.ToCurrency {
+            return fmt.Errorf("%w: rate #%d: currencies must differ", ErrValidation, i+1)
+        }
+    }
+    return s.repo.Upsert(ctx, rates)
+}
+
+func (s *exchangeRateService) ListRates(ctx context.Context, currency *string) ([]models.ExchangeRate, error) {
+    return s.repo.List(ctx, currency)
+}
+
+// LoadFromFile загружает курсы из CSV-файла с колонками
+// from_currency,to_currency,effective_date,rate (первая строка - заголовок)
+func (s *exchangeRateService) LoadFromFile(ctx context.Context, path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("failed to open exchange rates file: %w", err)
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    reader.FieldsPerRecord = 4
+    reader.TrimLeadingSpace = true
+
+    if _, err := reader.Read(); err != nil {
+        return fmt.Errorf("failed to read exchange rates header: %w", err)
+    }
+
+    var rates []models.ExchangeRate
+    for line := 2; ; line++ {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return fmt.Errorf("failed to read exchange rates file: %w", err)
+        }
+
+        date, err := time.Parse("2006-01-02", record[2])
+        if err != nil {
+            return fmt.Errorf("line %d: invalid effective_date: %w", line, err)
+        }
+        rate, err := models.ParseRate(record[3])
+        if err != nil {
+            return fmt.Errorf("line %d: %w", line, err)
+        }
+
+        rates = append(rates, models.ExchangeRate{
+            FromCurrency:  strings.ToUpper(record[0]),
+            ToCurrency:    strings.ToUpper(record[1]),
+            EffectiveDate: date,
+            Rate:          rate,
+        })
+    }
+
+    return s.SaveRates(ctx, rates)
+}
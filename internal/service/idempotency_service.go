@@ -0,0 +1,71 @@
+package service
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "subscription-service/internal/models"
+    "subscription-service/internal/repository"
+)
+
+// DefaultIdempotencyTTL - срок хранения ответа по ключу идемпотентности, если он не задан в конфигурации
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+var (
+    // ErrIdempotencyKeyReused возвращается, если ключ уже использован с другим запросом
+    ErrIdempotencyKeyReused = fmt.Errorf("%w: Idempotency-Key has already been used with a different request", ErrValidation)
+    // ErrIdempotencyKeyInUse возвращается, если первый запрос с этим ключом еще выполняется
+    ErrIdempotencyKeyInUse = fmt.Errorf("%w: a request with this Idempotency-Key is still in progress", ErrConflict)
+)
+
+type IdempotencyService interface {
+    Begin(ctx context.Context, scope, key, requestHash string) (*models.IdempotencyRecord, error)
+    Complete(ctx context.Context, scope, key string, statusCode int, contentType string, body []byte) error
+    Release(ctx context.Context, scope, key string) error
+    DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type idempotencyService struct {
+    repo repository.IdempotencyRepository
+    ttl  time.Duration
+}
+
+func NewIdempotencyService(repo repository.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+    if ttl <= 0 {
+        ttl = DefaultIdempotencyTTL
+    }
+    return &idempotencyService{repo: repo, ttl: ttl}
+}
+
+// Begin закрепляет ключ в области scope (метод и путь запроса) за запросом. Возвращает nil,
+// если запрос нужно выполнить, или сохраненный ответ, если такой запрос уже выполнялся.
+func (s *idempotencyService) Begin(ctx context.Context, scope, key, requestHash string) (*models.IdempotencyRecord, error) {
+    record, reserved, err := s.repo.Reserve(ctx, scope, key, requestHash, time.Now().Add(s.ttl))
+    if err != nil {
+        return nil, err
+    }
+    if reserved {
+        return nil, nil
+    }
+
+    if record.RequestHash != requestHash {
+        return nil, ErrIdempotencyKeyReused
+    }
+    if record.StatusCode == nil {
+        return nil, ErrIdempotencyKeyInUse
+    }
+    return record, nil
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, scope, key string, statusCode int, contentType string, body []byte) error {
+    return s.repo.Complete(ctx, scope, key, statusCode, contentType, body)
+}
+
+func (s *idempotencyService) Release(ctx context.Context, scope, key string) error {
+    return s.repo.Release(ctx, scope, key)
+}
+
+func (s *idempotencyService) DeleteExpired(ctx context.Context) (int64, error) {
+    return s.repo.DeleteExpired(ctx)
+}
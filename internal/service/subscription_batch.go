@@ -0,0 +1,110 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "subscription-service/internal/models"
+)
+
+// maxBatchOperations ограничивает размер пакета, чтобы транзакция не держала блокировки слишком долго
+const maxBatchOperations = 1000
+
+// ErrBatchAborted - итог операции, которая выполнилась, но была откачена из-за ошибки другой операции пакета
+var ErrBatchAborted = errors.New("operation was rolled back because another operation in the batch failed")
+
+// errBatchFailed откатывает транзакцию пакета в режиме atomic
+var errBatchFailed = errors.New("batch failed")
+
+// ApplyBatch выполняет операции в одной транзакции, каждую - в своей точке сохранения, поэтому ошибка одной
+// операции не мешает выполнить остальные. В режиме atomic любая ошибка откатывает весь пакет, в режиме
+// best_effort сохраняются успешные операции. Операции с ошибкой проверки (op.Err) не выполняются.
+// Ошибка возвращается, только если пакет не удалось обработать целиком.
+func (s *subscriptionService) ApplyBatch(ctx context.Context, mode models.BatchMode, ops []models.BatchOperation) (*models.BatchResult, error) {
+    if mode == "" {
+        mode = models.BatchAtomic
+    }
+    if len(ops) > maxBatchOperations {
+        return nil, newValidationError("operations", "max", fmt.Sprintf("must contain at most %d operations", maxBatchOperations))
+    }
+
+    result := &models.BatchResult{Mode: mode, Results: make([]models.BatchItemResult, len(ops))}
+
+    err := s.repo.WithinTx(ctx, func(ctx context.Context) error {
+        failed := false
+        for i := range ops {
+            item := &result.Results[i]
+            item.Index = i
+            item.Op = ops[i].Op
+
+            item.Err = ops[i].Err
+            if item.Err == nil {
+                item.Err = s.repo.WithinTx(ctx, func(ctx context.Context) error {
+                    return s.applyOperation(ctx, &ops[i], item)
+                })
+            }
+            if item.Err != nil {
+                failed = true
+            }
+        }
+
+        if failed && mode == models.BatchAtomic {
+            return errBatchFailed
+        }
+        return nil
+    })
+    if err != nil && !errors.Is(err, errBatchFailed) {
+        return nil, err
+    }
+
+    result.Committed = err == nil
+    for i := range result.Results {
+        item := &result.Results[i]
+        if item.Err == nil && !result.Committed {
+            item.Err = ErrBatchAborted
+            item.Subscription = nil
+        }
+
+        if item.Err != nil {
+            result.Failed++
+        } else {
+            result.Succeeded++
+        }
+    }
+
+    return result, nil
+}
+
+// applyOperation выполняет одну операцию пакета так же, как отдельный запрос, и записывает итог в item
+func (s *subscriptionService) applyOperation(ctx context.Context, op *models.BatchOperation, item *models.BatchItemResult) error {
+    var ifMatch []int64
+    if op.IfMatch != nil {
+        ifMatch = []int64{int64(*op.IfMatch)}
+    }
+
+    switch op.Op {
+    case models.BatchCreate:
+        sub := op.Create.Subscription()
+        if err := s.CreateSubscription(ctx, sub); err != nil {
+            return err
+        }
+        item.ID = &sub.ID
+        item.Subscription = sub
+    case models.BatchUpdate:
+        sub, err := s.UpdateSubscription(ctx, *op.ID, op.Update, ifMatch)
+        if err != nil {
+            return err
+        }
+        item.ID = &sub.ID
+        item.Subscription = sub
+    case models.BatchDelete:
+        if err := s.DeleteSubscription(ctx, *op.ID, nil, ifMatch); err != nil {
+            return err
+        }
+        item.ID = op.ID
+    default:
+        return newValidationError("op", "oneof", "must be one of: create, update, delete")
+    }
+    return nil
+}
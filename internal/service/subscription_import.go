@@ -0,0 +1,464 @@
+package service
+
+import (
+    "context"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+)
+
+const (
+    // importBatchSize - число строк, которые проверяются и сохраняются вместе: одной транзакцией и одним INSERT
+    importBatchSize = 500
+    // maxImportErrors ограничивает список ошибок в ответе, чтобы файл с неверным форматом не раздувал ответ
+    maxImportErrors = 1000
+)
+
+// importDateLayouts - допустимые форматы дат в файле; MM-YYYY и MM.YYYY означают первое число месяца
+var importDateLayouts = []string{"2006-01-02", "02.01.2006", "01-2006", "01.2006", time.RFC3339}
+
+// importRequiredFields - поля, без колонки для которых файл не загружается
+var importRequiredFields = map[string]bool{"service_name": true, "user_id": true, "start_date": true}
+
+// importColumns - номера колонок файла для полей подписки и названия этих колонок
+type importColumns struct {
+    index map[string]int
+    names map[string]string
+}
+
+type importRow struct {
+    line int
+    sub  *models.Subscription
+}
+
+// importState - то, что загрузка помнит между пачками
+type importState struct {
+    dryRun bool
+    // services - сервисы каталога по нормализованному названию; nil - названия нет в каталоге
+    services map[string]*models.Service
+    // keys и pending нужны пробной загрузке, которая ничего не сохраняет: естественные ключи принятых строк
+    // и число принятых действующих подписок каждого пользователя. При настоящей загрузке это видно в базе.
+    keys    map[string]bool
+    pending map[uuid.UUID]int
+}
+
+func newImportState(dryRun bool) *importState {
+    return &importState{
+        dryRun:   dryRun,
+        services: map[string]*models.Service{},
+        keys:     map[string]bool{},
+        pending:  map[uuid.UUID]int{},
+    }
+}
+
+// ImportSubscriptions загружает подписки из CSV с заголовком. Строки проходят те же проверки, что и в
+// CreateSubscription; строки с ошибками пропускаются и перечисляются в ответе. Файл читается потоком и
+// сохраняется пачками по importBatchSize строк, каждая - одним INSERT в своей транзакции, поэтому ошибка
+// чтения посреди файла оставляет уже сохраненные пачки. DryRun выполняет те же проверки, ничего не записывая;
+// дубликаты и лимит учитывают строки, принятые из этого же файла раньше.
+func (s *subscriptionService) ImportSubscriptions(ctx context.Context, file io.Reader, opts *models.ImportOptions) (*models.ImportResult, error) {
+    reader := csv.NewReader(file)
+    if opts.Delimiter != 0 {
+        reader.Comma = opts.Delimiter
+    }
+    reader.TrimLeadingSpace = true
+    reader.ReuseRecord = true
+
+    header, err := reader.Read()
+    if err == io.EOF {
+        return nil, newValidationError("file", "required", "must contain a header row")
+    }
+    var parseErr *csv.ParseError
+    if errors.As(err, &parseErr) {
+        return nil, newValidationError("file", "csv", parseErr.Error())
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read CSV file: %w", err)
+    }
+
+    columns, err := newImportColumns(header, opts.Columns)
+    if err != nil {
+        return nil, err
+    }
+
+    result := &models.ImportResult{DryRun: opts.DryRun, Errors: []models.ImportRowError{}}
+    if err := s.importRows(ctx, reader, columns, result, newImportState(opts.DryRun)); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// importRows читает строки пачками и загружает каждую пачку
+func (s *subscriptionService) importRows(ctx context.Context, reader *csv.Reader, columns *importColumns, result *models.ImportResult, state *importState) error {
+    for {
+        batch, eof, err := readImportBatch(reader, columns, result)
+        if err != nil {
+            return err
+        }
+
+        if len(batch) > 0 {
+            if err := s.importBatch(ctx, batch, columns, result, state); err != nil {
+                return err
+            }
+        }
+
+        if eof {
+            return nil
+        }
+    }
+}
+
+// importBatch проверяет строки пачки и сохраняет прошедшие проверки. Ошибки строк попадают в результат
+// в порядке строк файла.
+func (s *subscriptionService) importBatch(ctx context.Context, batch []importRow, columns *importColumns, result *models.ImportResult, state *importState) error {
+    errs := make([]error, len(batch))
+    for i, row := range batch {
+        if err := s.prepareImported(ctx, row.sub, state); err != nil {
+            if !isDomainError(err) {
+                return err
+            }
+            errs[i] = err
+        }
+    }
+
+    var err error
+    if state.dryRun {
+        _, err = s.checkImportBatch(ctx, batch, errs, state)
+    } else {
+        err = s.repo.WithinTx(ctx, func(ctx context.Context) error {
+            return s.saveImportBatch(ctx, batch, errs, state)
+        })
+    }
+    if err != nil {
+        return err
+    }
+
+    for i, row := range batch {
+        if errs[i] != nil {
+            addImportError(result, columns.rowError(row.line, errs[i]))
+            continue
+        }
+        result.Imported++
+    }
+    return nil
+}
+
+// prepareImported - prepareNew для строки файла. Сервисы каталога запоминаются на всю загрузку,
+// чтобы повторяющиеся названия не искались в каталоге для каждой строки.
+func (s *subscriptionService) prepareImported(ctx context.Context, sub *models.Subscription, state *importState) error {
+    if err := validateSubscription(sub); err != nil {
+        return err
+    }
+
+    name := models.NormalizeServiceName(sub.ServiceName)
+    svc, ok := state.services[name]
+    if !ok {
+        var err error
+        if svc, err = s.resolveService(ctx, nil, sub.ServiceName); err != nil {
+            return err
+        }
+        state.services[name] = svc
+    }
+    return applyCatalog(sub, svc)
+}
+
+// saveImportBatch блокирует пользователей пачки, как withinUserLimit, проверяет строки и сохраняет
+// принятые одним запросом. Вызывается в транзакции.
+func (s *subscriptionService) saveImportBatch(ctx context.Context, batch []importRow, errs []error, state *importState) error {
+    if err := s.repo.LockUsers(ctx, importUsers(batch, errs)...); err != nil {
+        return err
+    }
+
+    accepted, err := s.checkImportBatch(ctx, batch, errs, state)
+    if err != nil || len(accepted) == 0 {
+        return err
+    }
+
+    subs := make([]*models.Subscription, len(accepted))
+    for j, i := range accepted {
+        subs[j] = batch[i].sub
+    }
+    created, err := s.repo.CreateMany(ctx, subs)
+    if err != nil {
+        return err
+    }
+    for j, i := range accepted {
+        // ON CONFLICT пропустил строку: ключ заняли в обход блокировки пользователя
+        if !created[j] {
+            errs[i] = ErrSubscriptionExists
+        }
+    }
+    return nil
+}
+
+// checkImportBatch отмечает в errs дубликаты и строки сверх лимита подписок пользователя и возвращает номера
+// принятых строк пачки. Дубликат - строка с естественным ключом, который уже есть в базе или принят из файла раньше.
+// Строки проверяются по порядку, как если бы они создавались по одной.
+func (s *subscriptionService) checkImportBatch(ctx context.Context, batch []importRow, errs []error, state *importState) ([]int, error) {
+    var subs []*models.Subscription
+    var rows []int
+    for i, row := range batch {
+        if errs[i] == nil {
+            subs = append(subs, row.sub)
+            rows = append(rows, i)
+        }
+    }
+    if len(subs) == 0 {
+        return nil, nil
+    }
+
+    exists, err := s.repo.ExistingKeys(ctx, subs)
+    if err != nil {
+        return nil, err
+    }
+    counts, err := s.repo.CountActiveByUsers(ctx, importUsers(batch, errs))
+    if err != nil {
+        return nil, err
+    }
+
+    keys, pending := state.keys, state.pending
+    if !state.dryRun {
+        keys, pending = map[string]bool{}, map[uuid.UUID]int{}
+    }
+    today := time.Now().UTC().Truncate(24 * time.Hour)
+
+    accepted := make([]int, 0, len(rows))
+    for j, i := range rows {
+        sub := subs[j]
+        key := sub.NaturalKey()
+        if exists[j] || keys[key] {
+            errs[i] = ErrSubscriptionExists
+            continue
+        }
+        if count := counts[sub.UserID] + pending[sub.UserID]; count >= maxSubscriptionsPerUser {
+            errs[i] = userLimitError(count)
+            continue
+        }
+
+        keys[key] = true
+        if sub.EndDate == nil || !sub.EndDate.Before(today) {
+            pending[sub.UserID]++
+        }
+        accepted = append(accepted, i)
+    }
+    return accepted, nil
+}
+
+// importUsers возвращает пользователей строк пачки без ошибок
+func importUsers(batch []importRow, errs []error) []uuid.UUID {
+    seen := make(map[uuid.UUID]bool)
+    var users []uuid.UUID
+    for i, row := range batch {
+        if errs[i] == nil && !seen[row.sub.UserID] {
+            seen[row.sub.UserID] = true
+            users = append(users, row.sub.UserID)
+        }
+    }
+    return users
+}
+
+// readImportBatch читает до importBatchSize строк. Строки, которые не удалось разобрать, сразу попадают в ошибки.
+func readImportBatch(reader *csv.Reader, columns *importColumns, result *models.ImportResult) (batch []importRow, eof bool, err error) {
+    for len(batch) < importBatchSize {
+        record, err := reader.Read()
+        if err == io.EOF {
+            return batch, true, nil
+        }
+
+        var parseErr *csv.ParseError
+        if errors.As(err, &parseErr) {
+            result.Rows++
+            addImportError(result, models.ImportRowError{Row: parseErr.StartLine, Message: parseErr.Err.Error()})
+            continue
+        }
+        if err != nil {
+            return nil, false, fmt.Errorf("failed to read CSV file: %w", err)
+        }
+
+        result.Rows++
+        line, _ := reader.FieldPos(0)
+        sub, errs := columns.parse(record)
+        if errs != nil {
+            addImportError(result, columns.rowError(line, errs.err()))
+            continue
+        }
+        batch = append(batch, importRow{line: line, sub: sub})
+    }
+    return batch, false, nil
+}
+
+// newImportColumns находит в заголовке колонки для полей подписки. mapping задает названия колонок,
+// отличные от названий полей; колонки сравниваются без учета регистра и пробелов по краям.
+func newImportColumns(header []string, mapping map[string]string) (*importColumns, error) {
+    var errs fieldErrors
+    unknown := make([]string, 0, len(mapping))
+    for field := range mapping {
+        if !isImportField(field) {
+            unknown = append(unknown, field)
+        }
+    }
+    sort.Strings(unknown)
+    for _, field := range unknown {
+        errs.add(importMappingParam(field), "oneof", "must be one of: "+strings.Join(models.ImportFields, ", "))
+    }
+
+    positions := make(map[string]int, len(header))
+    for i, name := range header {
+        // Excel добавляет BOM в начало файла в UTF-8
+        if i == 0 {
+            name = strings.TrimPrefix(name, "\ufeff")
+        }
+        name = strings.ToLower(strings.TrimSpace(name))
+        if _, ok := positions[name]; !ok {
+            positions[name] = i
+        }
+    }
+
+    columns := &importColumns{index: map[string]int{}, names: map[string]string{}}
+    for _, field := range models.ImportFields {
+        name, mapped := mapping[field]
+        if !mapped {
+            name = field
+        }
+
+        i, ok := positions[strings.ToLower(strings.TrimSpace(name))]
+        if !ok {
+            if mapped || importRequiredFields[field] {
+                errs.add(importMappingParam(field), "column", fmt.Sprintf("column %q is not in the file header", name))
+            }
+            continue
+        }
+        columns.index[field] = i
+        columns.names[field] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+    }
+
+    if err := errs.err(); err != nil {
+        return nil, err
+    }
+    return columns, nil
+}
+
+func isImportField(field string) bool {
+    for _, f := range models.ImportFields {
+        if f == field {
+            return true
+        }
+    }
+    return false
+}
+
+// importMappingParam называет параметр запроса, которым задается колонка поля
+func importMappingParam(field string) string {
+    return "columns[" + field + "]"
+}
+
+// value возвращает значение поля в строке; пустая строка, если колонки для поля нет
+func (c *importColumns) value(record []string, field string) string {
+    i, ok := c.index[field]
+    if !ok {
+        return ""
+    }
+    return strings.TrimSpace(record[i])
+}
+
+// parse разбирает строку в подписку. Проверки значений остаются prepareImported, здесь - только формат.
+func (c *importColumns) parse(record []string) (*models.Subscription, fieldErrors) {
+    var errs fieldErrors
+    sub := &models.Subscription{
+        ServiceName:   c.value(record, "service_name"),
+        BillingPeriod: models.BillingMonthly,
+    }
+
+    if value := c.value(record, "price"); value != "" {
+        price, err := parseImportPrice(value)
+        if err != nil {
+            errs.add(c.names["price"], "format", "must be an amount such as 299.00 or 299,00")
+        }
+        sub.Price = price
+    }
+
+    if value := c.value(record, "user_id"); value == "" {
+        errs.add(c.names["user_id"], "required", "is required")
+    } else if userID, err := uuid.Parse(value); err != nil {
+        errs.add(c.names["user_id"], "uuid", "must be a UUID")
+    } else {
+        sub.UserID = userID
+    }
+
+    if value := c.value(record, "start_date"); value == "" {
+        errs.add(c.names["start_date"], "required", "is required")
+    } else if startDate, ok := parseImportDate(value); !ok {
+        errs.add(c.names["start_date"], "date", importDateMessage)
+    } else {
+        sub.StartDate = startDate
+    }
+
+    if value := c.value(record, "end_date"); value != "" {
+        if endDate, ok := parseImportDate(value); !ok {
+            errs.add(c.names["end_date"], "date", importDateMessage)
+        } else {
+            sub.EndDate = &endDate
+        }
+    }
+
+    return sub, errs
+}
+
+// rowError описывает ошибку строки; поля подписки в ошибках проверки заменяются названиями колонок файла
+func (c *importColumns) rowError(line int, err error) models.ImportRowError {
+    var validationErr *ValidationError
+    if !errors.As(err, &validationErr) {
+        return models.ImportRowError{Row: line, Message: err.Error()}
+    }
+
+    fields := make([]models.FieldError, len(validationErr.Fields))
+    for i, f := range validationErr.Fields {
+        if name, ok := c.names[f.Field]; ok {
+            f.Field = name
+        }
+        fields[i] = f
+    }
+    return models.ImportRowError{Row: line, Message: "Row failed validation", Errors: fields}
+}
+
+func addImportError(result *models.ImportResult, rowErr models.ImportRowError) {
+    result.Failed++
+    if len(result.Errors) >= maxImportErrors {
+        result.ErrorsTruncated = true
+        return
+    }
+    result.Errors = append(result.Errors, rowErr)
+}
+
+const importDateMessage = "must be a date in YYYY-MM-DD, DD.MM.YYYY, MM-YYYY, MM.YYYY or RFC 3339 format"
+
+func parseImportDate(value string) (time.Time, bool) {
+    for _, layout := range importDateLayouts {
+        if date, err := time.Parse(layout, value); err == nil {
+            return date.UTC(), true
+        }
+    }
+    return time.Time{}, false
+}
+
+// parseImportPrice разбирает сумму в том виде, в каком ее выгружают таблицы:
+// с пробелами между разрядами и десятичной запятой
+func parseImportPrice(value string) (models.Money, error) {
+    value = strings.NewReplacer(" ", "", "\u00a0", "", ",", ".").Replace(value)
+    return models.ParseMoney(value)
+}
+
+// isDomainError отличает ошибки данных, которые относятся к одной строке, от сбоев, после которых загрузку не продолжить
+func isDomainError(err error) bool {
+    return errors.Is(err, ErrValidation) ||
+        errors.Is(err, ErrNotFound) ||
+        errors.Is(err, ErrConflict) ||
+        errors.Is(err, ErrPreconditionFailed)
+}
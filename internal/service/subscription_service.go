@@ -2,6 +2,10 @@ package service
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "io"
+    "time"
 
     "github.com/google/uuid"
     "subscription-service/internal/models"
@@ -10,41 +14,375 @@ import (
 
 type SubscriptionService interface {
     CreateSubscription(ctx context.Context, sub *models.Subscription) error
-    GetSubscription(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
-    UpdateSubscription(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest) error
-    DeleteSubscription(ctx context.Context, id uuid.UUID) error
-    ListSubscriptions(ctx context.Context, userID *uuid.UUID, serviceName *string) ([]*models.Subscription, error)
+    UpsertSubscription(ctx context.Context, sub *models.Subscription) (created bool, err error)
+    GetSubscription(ctx context.Context, id uuid.UUID, asOf *time.Time) (*models.Subscription, error)
+    UpdateSubscription(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest, ifMatch []int64) (*models.Subscription, error)
+    PatchSubscription(ctx context.Context, id uuid.UUID, patch func(req *models.UpdateSubscriptionRequest) error, ifMatch []int64) (*models.Subscription, error)
+    DeleteSubscription(ctx context.Context, id uuid.UUID, deletedAt *time.Time, ifMatch []int64) error
+    RestoreSubscription(ctx context.Context, id uuid.UUID) (*models.Subscription, error)
+    PurgeDeleted(ctx context.Context, olderThanDays int) (int64, error)
+    GetHistory(ctx context.Context, id uuid.UUID) ([]models.SubscriptionEvent, error)
+    SchedulePriceChange(ctx context.Context, id uuid.UUID, change *models.PriceChange) (*models.Subscription, error)
+    ListSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest) (*models.SubscriptionPage, error)
+    ExportSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest, fn func(sub *models.Subscription) error) error
     GetSummary(ctx context.Context, req *models.SummaryRequest) (*models.SubscriptionSummary, error)
+    ExportSummary(ctx context.Context, req *models.SummaryRequest, fn func(item *models.SubscriptionCost) error) error
+    GetTimeSeries(ctx context.Context, req *models.TimeSeriesRequest) (*models.TimeSeries, error)
+    ListUpcomingCharges(ctx context.Context, req *models.UpcomingRequest) (*models.UpcomingCharges, error)
+    ApplyBatch(ctx context.Context, mode models.BatchMode, ops []models.BatchOperation) (*models.BatchResult, error)
+    ImportSubscriptions(ctx context.Context, file io.Reader, opts *models.ImportOptions) (*models.ImportResult, error)
 }
 
+var (
+    // ErrInvalidCursor возвращается, если курсор страницы поврежден или выдан для другой сортировки
+    ErrInvalidCursor = repository.ErrInvalidCursor
+    // ErrVersionMismatch возвращается, если версия подписки не совпала с If-Match
+    ErrVersionMismatch = repository.ErrVersionMismatch
+    // ErrRestoreConflict возвращается, если вместо удаленной подписки уже заведена такая же
+    ErrRestoreConflict = repository.ErrRestoreConflict
+    // ErrPriceChangeExists возвращается, если на эту дату изменение цены уже запланировано
+    ErrPriceChangeExists = repository.ErrPriceChangeExists
+)
+
+// maxPatchAttempts ограничивает число повторов PATCH при конкурентных изменениях подписки
+const maxPatchAttempts = 3
+
 type subscriptionService struct {
-    repo repository.SubscriptionRepository
+    repo    repository.SubscriptionRepository
+    catalog repository.CatalogRepository
 }
 
-func NewSubscriptionService(repo repository.SubscriptionRepository) SubscriptionService {
-    return &subscriptionService{repo: repo}
+func NewSubscriptionService(repo repository.SubscriptionRepository, catalog repository.CatalogRepository) SubscriptionService {
+    return &subscriptionService{repo: repo, catalog: catalog}
 }
 
 func (s *subscriptionService) CreateSubscription(ctx context.Context, sub *models.Subscription) error {
-    return s.repo.Create(ctx, sub)
+    if err := s.prepareNew(ctx, sub); err != nil {
+        return err
+    }
+
+    return s.withinUserLimit(ctx, sub.UserID, uuid.Nil, func(ctx context.Context) error {
+        return s.repo.Create(ctx, sub)
+    })
+}
+
+// UpsertSubscription создает подписку или заменяет существующую с тем же пользователем, сервисом и датой начала
+func (s *subscriptionService) UpsertSubscription(ctx context.Context, sub *models.Subscription) (bool, error) {
+    if err := s.prepareNew(ctx, sub); err != nil {
+        return false, err
+    }
+
+    var created bool
+    err := s.repo.WithinTx(ctx, func(ctx context.Context) error {
+        // Блокировка берется до поиска по ключу, чтобы параллельный запрос не создал подписку между ними
+        if err := s.repo.LockUsers(ctx, sub.UserID); err != nil {
+            return err
+        }
+
+        // Лимит касается только новых подписок: замена существующей не увеличивает их число
+        _, err := s.repo.GetByKey(ctx, sub.UserID, sub.ServiceName, sub.StartDate)
+        switch {
+        case errors.Is(err, ErrSubscriptionNotFound):
+            if err := s.checkUserLimit(ctx, sub.UserID, uuid.Nil); err != nil {
+                return err
+            }
+        case err != nil:
+            return err
+        }
+
+        created, err = s.repo.Upsert(ctx, sub)
+        return err
+    })
+    return created, err
+}
+
+// prepareNew проверяет новую подписку и дополняет ее данными каталога: каноническим названием,
+// справочной ценой и валютой
+func (s *subscriptionService) prepareNew(ctx context.Context, sub *models.Subscription) error {
+    if err := validateSubscription(sub); err != nil {
+        return err
+    }
+
+    svc, err := s.resolveService(ctx, sub.ServiceID, sub.ServiceName)
+    if err != nil {
+        return err
+    }
+    return applyCatalog(sub, svc)
+}
+
+// applyCatalog дополняет проверенную новую подписку данными сервиса svc; nil - сервиса нет в каталоге
+func applyCatalog(sub *models.Subscription, svc *models.Service) error {
+    if svc != nil {
+        sub.ServiceID = &svc.ID
+        sub.ServiceName = svc.Name
+
+        if sub.Price == 0 {
+            sub.Price = svc.DefaultPrices[sub.BillingPeriod]
+            if sub.Currency == "" {
+                sub.Currency = svc.Currency
+            }
+        }
+    }
+
+    if sub.Price == 0 {
+        return newValidationError("price", "required", "is required: the catalog has no default price for this billing period")
+    }
+    if sub.Currency == "" {
+        sub.Currency = models.DefaultCurrency
+    }
+    return nil
+}
+
+func (s *subscriptionService) GetSubscription(ctx context.Context, id uuid.UUID, asOf *time.Time) (*models.Subscription, error) {
+    return s.repo.GetByID(ctx, id, asOf)
 }
 
-func (s *subscriptionService) GetSubscription(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
-    return s.repo.GetByID(ctx, id)
+// UpdateSubscription полностью заменяет подписку данными req
+func (s *subscriptionService) UpdateSubscription(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest, ifMatch []int64) (*models.Subscription, error) {
+    current, err := s.repo.GetByID(ctx, id, nil)
+    if err != nil {
+        return nil, err
+    }
+    return s.replace(ctx, current, req, ifMatch)
 }
 
-func (s *subscriptionService) UpdateSubscription(ctx context.Context, id uuid.UUID, req *models.UpdateSubscriptionRequest) error {
-    return s.repo.Update(ctx, id, req)
+// replace записывает req поверх текущего состояния подписки current
+func (s *subscriptionService) replace(ctx context.Context, current *models.Subscription, req *models.UpdateSubscriptionRequest, ifMatch []int64) (*models.Subscription, error) {
+    id := current.ID
+    sub := &models.Subscription{
+        ID:               id,
+        ServiceName:      req.ServiceName,
+        Price:            req.Price,
+        Currency:         req.Currency,
+        BillingPeriod:    req.BillingPeriod,
+        BillingAnchorDay: req.BillingAnchorDay,
+        UserID:           req.UserID,
+        StartDate:        req.StartDate,
+        EndDate:          req.EndDate,
+    }
+
+    if err := validateSubscription(sub); err != nil {
+        return nil, err
+    }
+    if sub.Price == 0 {
+        return nil, newValidationError("price", "required", "is required")
+    }
+
+    svc, err := s.resolveService(ctx, req.ServiceID, req.ServiceName)
+    if err != nil {
+        return nil, err
+    }
+    // Название без записи в каталоге хранится свободным текстом без привязки к сервису
+    if svc != nil {
+        sub.ServiceID = &svc.ID
+        sub.ServiceName = svc.Name
+    }
+
+    // Лимит проверяется, только когда подписка переходит к другому пользователю,
+    // чтобы его снижение не блокировало правку уже существующих подписок
+    if sub.UserID != current.UserID {
+        err = s.withinUserLimit(ctx, sub.UserID, id, func(ctx context.Context) error {
+            return s.repo.Update(ctx, sub, ifMatch)
+        })
+    } else {
+        err = s.repo.Update(ctx, sub, ifMatch)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return sub, nil
 }
 
-func (s *subscriptionService) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
-    return s.repo.Delete(ctx, id)
+// PatchSubscription изменяет подписку функцией patch, которая получает текущее состояние подписки.
+// Без ifMatch изменение, потерявшее гонку с другим запросом, повторяется на свежих данных.
+func (s *subscriptionService) PatchSubscription(ctx context.Context, id uuid.UUID, patch func(req *models.UpdateSubscriptionRequest) error, ifMatch []int64) (*models.Subscription, error) {
+    for attempt := 1; ; attempt++ {
+        current, err := s.repo.GetByID(ctx, id, nil)
+        if err != nil {
+            return nil, err
+        }
+        if ifMatch != nil && !containsVersion(ifMatch, current.Version) {
+            return nil, ErrVersionMismatch
+        }
+
+        req := current.UpdateRequest()
+        if err := patch(&req); err != nil {
+            return nil, err
+        }
+
+        sub, err := s.replace(ctx, current, &req, []int64{int64(current.Version)})
+        if errors.Is(err, ErrVersionMismatch) && ifMatch == nil && attempt < maxPatchAttempts {
+            continue
+        }
+        return sub, err
+    }
 }
 
-func (s *subscriptionService) ListSubscriptions(ctx context.Context, userID *uuid.UUID, serviceName *string) ([]*models.Subscription, error) {
-    return s.repo.List(ctx, userID, serviceName)
+// DeleteSubscription помечает подписку удаленной моментом deletedAt, по умолчанию - текущим.
+// Прошедший момент нужен синхронизации, которая переносит удаление из другой системы;
+// будущий не принимается: подписка пропала бы из списков раньше, чем удаление произошло.
+func (s *subscriptionService) DeleteSubscription(ctx context.Context, id uuid.UUID, deletedAt *time.Time, ifMatch []int64) error {
+    now := time.Now().UTC()
+    at := now
+    if deletedAt != nil {
+        if deletedAt.After(now) {
+            return newValidationError("deleted_at", "max", "must not be in the future")
+        }
+        at = deletedAt.UTC()
+    }
+    return s.repo.Delete(ctx, id, at, ifMatch)
+}
+
+func (s *subscriptionService) RestoreSubscription(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+    sub, err := s.repo.GetDeleted(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+
+    var restored *models.Subscription
+    err = s.withinUserLimit(ctx, sub.UserID, id, func(ctx context.Context) error {
+        var err error
+        restored, err = s.repo.Restore(ctx, id)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return restored, nil
+}
+
+// PurgeDeleted окончательно удаляет подписки, удаленные больше olderThanDays дней назад
+func (s *subscriptionService) PurgeDeleted(ctx context.Context, olderThanDays int) (int64, error) {
+    // Граница в будущем удалила бы и подписки, которые только что отправлены в корзину
+    if olderThanDays < 0 {
+        return 0, newValidationError("older_than_days", "min", "must not be negative")
+    }
+
+    cutoff := time.Now().UTC().AddDate(0, 0, -olderThanDays)
+    return s.repo.Purge(ctx, cutoff)
+}
+
+func (s *subscriptionService) GetHistory(ctx context.Context, id uuid.UUID) ([]models.SubscriptionEvent, error) {
+    return s.repo.History(ctx, id)
+}
+
+// SchedulePriceChange добавляет новую цену подписки с даты change.EffectiveFrom.
+// Прошедшие даты не принимаются, чтобы не менять уже закрытые отчеты.
+func (s *subscriptionService) SchedulePriceChange(ctx context.Context, id uuid.UUID, change *models.PriceChange) (*models.Subscription, error) {
+    sub, err := s.repo.GetByID(ctx, id, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    now := time.Now().UTC()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    from := change.EffectiveFrom.UTC()
+    change.EffectiveFrom = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+
+    var errs fieldErrors
+    switch {
+    case change.EffectiveFrom.Before(today):
+        errs.add("effective_from", "min", "must not be in the past")
+    case !change.EffectiveFrom.After(sub.StartDate):
+        errs.add("effective_from", "gtfield", "must be after start_date; change the price itself instead")
+    case sub.EndDate != nil && change.EffectiveFrom.After(*sub.EndDate):
+        errs.add("effective_from", "ltefield", "must not be after end_date")
+    }
+    validatePrice(&errs, "price", change.Price)
+    if err := errs.err(); err != nil {
+        return nil, err
+    }
+
+    if err := s.repo.AddPriceChange(ctx, id, change); err != nil {
+        return nil, err
+    }
+
+    return s.repo.GetByID(ctx, id, nil)
+}
+
+func (s *subscriptionService) ListSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest) (*models.SubscriptionPage, error) {
+    return s.repo.List(ctx, filter, page)
+}
+
+// ExportSubscriptions передает fn все подписки, подходящие под фильтр, без постраничной разбивки
+func (s *subscriptionService) ExportSubscriptions(ctx context.Context, filter *models.SubscriptionFilter, page *models.PageRequest, fn func(sub *models.Subscription) error) error {
+    return s.repo.ExportList(ctx, filter, page, fn)
 }
 
 func (s *subscriptionService) GetSummary(ctx context.Context, req *models.SummaryRequest) (*models.SubscriptionSummary, error) {
     return s.repo.GetSummary(ctx, req)
-}
\ No newline at end of file
+}
+
+// ExportSummary передает fn стоимость каждой подписки за период
+func (s *subscriptionService) ExportSummary(ctx context.Context, req *models.SummaryRequest, fn func(item *models.SubscriptionCost) error) error {
+    return s.repo.ExportSummary(ctx, req, fn)
+}
+
+func (s *subscriptionService) GetTimeSeries(ctx context.Context, req *models.TimeSeriesRequest) (*models.TimeSeries, error) {
+    return s.repo.GetTimeSeries(ctx, req)
+}
+
+func (s *subscriptionService) ListUpcomingCharges(ctx context.Context, req *models.UpcomingRequest) (*models.UpcomingCharges, error) {
+    return s.repo.ListUpcomingCharges(ctx, req)
+}
+
+// resolveService находит сервис каталога по ID или по названию/синониму.
+// Для названия, которого нет в каталоге, возвращает nil: такие подписки хранятся свободным текстом.
+func (s *subscriptionService) resolveService(ctx context.Context, id *uuid.UUID, name string) (*models.Service, error) {
+    if id != nil {
+        svc, err := s.catalog.GetByID(ctx, *id)
+        if errors.Is(err, repository.ErrServiceNotFound) {
+            return nil, fmt.Errorf("%w: unknown service_id %s", ErrValidation, *id)
+        }
+        return svc, err
+    }
+
+    svc, err := s.catalog.Resolve(ctx, name)
+    if errors.Is(err, repository.ErrServiceNotFound) {
+        return nil, nil
+    }
+    return svc, err
+}
+
+// withinUserLimit выполняет запись fn в одной транзакции с проверкой лимита подписок пользователя userID.
+// Пользователь блокируется до конца транзакции, поэтому параллельные запросы проверяют лимит по очереди
+// и не могут вместе его превысить.
+func (s *subscriptionService) withinUserLimit(ctx context.Context, userID, except uuid.UUID, fn func(ctx context.Context) error) error {
+    return s.repo.WithinTx(ctx, func(ctx context.Context) error {
+        if err := s.repo.LockUsers(ctx, userID); err != nil {
+            return err
+        }
+        if err := s.checkUserLimit(ctx, userID, except); err != nil {
+            return err
+        }
+        return fn(ctx)
+    })
+}
+
+// checkUserLimit проверяет, что у пользователя есть место еще для одной действующей подписки.
+// Подписка except не учитывается: она уже принадлежит пользователю или восстанавливается.
+// Проверка надежна, только если пользователь заблокирован в той же транзакции (см. withinUserLimit).
+func (s *subscriptionService) checkUserLimit(ctx context.Context, userID, except uuid.UUID) error {
+    count, err := s.repo.CountActiveByUser(ctx, userID, except)
+    if err != nil {
+        return err
+    }
+    if count >= maxSubscriptionsPerUser {
+        return userLimitError(count)
+    }
+    return nil
+}
+
+// userLimitError - ошибка проверки для пользователя, у которого уже count действующих подписок
+func userLimitError(count int) error {
+    return newValidationError("user_id", "limit", fmt.Sprintf("already has %d active subscriptions, the maximum is %d", count, maxSubscriptionsPerUser))
+}
+
+func containsVersion(versions []int64, version int) bool {
+    for _, v := range versions {
+        if v == int64(version) {
+            return true
+        }
+    }
+    return false
+}
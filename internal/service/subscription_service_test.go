@@ -0,0 +1,1183 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "subscription-service/internal/models"
+    "subscription-service/internal/repository"
+)
+
+// fakeSubscriptionRepo хранит подписки в памяти. Встроенный интерфейс остается nil,
+// поэтому вызов метода, который тест не ожидает, сразу приводит к панике.
+type fakeSubscriptionRepo struct {
+    repository.SubscriptionRepository
+
+    subs        map[uuid.UUID]*models.Subscription
+    deleted     map[uuid.UUID]*models.Subscription
+    activeCount int
+    // locked - пользователи, заблокированные через LockUsers; countedUnlocked - был ли подсчет без блокировки
+    locked          map[uuid.UUID]bool
+    countedUnlocked bool
+
+    created      []*models.Subscription
+    updated      []*models.Subscription
+    restored     []uuid.UUID
+    purgedBefore *time.Time
+    priceChanges []*models.PriceChange
+}
+
+func newFakeSubscriptionRepo(subs ...*models.Subscription) *fakeSubscriptionRepo {
+    repo := &fakeSubscriptionRepo{
+        subs:    map[uuid.UUID]*models.Subscription{},
+        deleted: map[uuid.UUID]*models.Subscription{},
+        locked:  map[uuid.UUID]bool{},
+    }
+    for _, sub := range subs {
+        repo.subs[sub.ID] = sub
+    }
+    return repo
+}
+
+// WithinTx не откатывает изменения в памяти: тесты проверяют итоги операций, а не состояние хранилища
+func (r *fakeSubscriptionRepo) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+    return fn(ctx)
+}
+
+func (r *fakeSubscriptionRepo) Create(ctx context.Context, sub *models.Subscription) error {
+    sub.ID = uuid.New()
+    r.created = append(r.created, sub)
+    return nil
+}
+
+func (r *fakeSubscriptionRepo) GetByID(ctx context.Context, id uuid.UUID, asOf *time.Time) (*models.Subscription, error) {
+    sub, ok := r.subs[id]
+    if !ok {
+        return nil, repository.ErrSubscriptionNotFound
+    }
+    copied := *sub
+    return &copied, nil
+}
+
+func (r *fakeSubscriptionRepo) GetByKey(ctx context.Context, userID uuid.UUID, serviceName string, startDate time.Time) (*models.Subscription, error) {
+    for _, sub := range r.subs {
+        if sub.UserID == userID && models.NormalizeServiceName(sub.ServiceName) == models.NormalizeServiceName(serviceName) && sub.StartDate.Equal(startDate) {
+            return sub, nil
+        }
+    }
+    return nil, repository.ErrSubscriptionNotFound
+}
+
+func (r *fakeSubscriptionRepo) Upsert(ctx context.Context, sub *models.Subscription) (bool, error) {
+    existing, err := r.GetByKey(ctx, sub.UserID, sub.ServiceName, sub.StartDate)
+    if err != nil {
+        return true, r.Create(ctx, sub)
+    }
+    sub.ID = existing.ID
+    r.updated = append(r.updated, sub)
+    return false, nil
+}
+
+func (r *fakeSubscriptionRepo) GetDeleted(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+    sub, ok := r.deleted[id]
+    if !ok {
+        return nil, repository.ErrSubscriptionNotFound
+    }
+    return sub, nil
+}
+
+func (r *fakeSubscriptionRepo) CountActiveByUser(ctx context.Context, userID uuid.UUID, except uuid.UUID) (int, error) {
+    if !r.locked[userID] {
+        r.countedUnlocked = true
+    }
+    return r.activeCount, nil
+}
+
+// CountActiveByUsers, как и CountActiveByUser, отвечает activeCount для каждого пользователя
+func (r *fakeSubscriptionRepo) CountActiveByUsers(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+    counts := make(map[uuid.UUID]int, len(userIDs))
+    for _, userID := range userIDs {
+        if !r.locked[userID] {
+            r.countedUnlocked = true
+        }
+        counts[userID] = r.activeCount
+    }
+    return counts, nil
+}
+
+func (r *fakeSubscriptionRepo) LockUsers(ctx context.Context, userIDs ...uuid.UUID) error {
+    for _, userID := range userIDs {
+        r.locked[userID] = true
+    }
+    return nil
+}
+
+func (r *fakeSubscriptionRepo) ExistingKeys(ctx context.Context, subs []*models.Subscription) ([]bool, error) {
+    exists := make([]bool, len(subs))
+    for i, sub := range subs {
+        _, err := r.GetByKey(ctx, sub.UserID, sub.ServiceName, sub.StartDate)
+        exists[i] = err == nil
+    }
+    return exists, nil
+}
+
+// CreateMany сохраняет подписки в subs, чтобы следующие пачки видели их ключи
+func (r *fakeSubscriptionRepo) CreateMany(ctx context.Context, subs []*models.Subscription) ([]bool, error) {
+    created := make([]bool, len(subs))
+    for i, sub := range subs {
+        if _, err := r.GetByKey(ctx, sub.UserID, sub.ServiceName, sub.StartDate); err == nil {
+            continue
+        }
+        if err := r.Create(ctx, sub); err != nil {
+            return nil, err
+        }
+        r.subs[sub.ID] = sub
+        created[i] = true
+    }
+    return created, nil
+}
+
+func (r *fakeSubscriptionRepo) Update(ctx context.Context, sub *models.Subscription, ifMatch []int64) error {
+    r.updated = append(r.updated, sub)
+    return nil
+}
+
+func (r *fakeSubscriptionRepo) Delete(ctx context.Context, id uuid.UUID, deletedAt time.Time, ifMatch []int64) error {
+    sub, ok := r.subs[id]
+    if !ok {
+        return repository.ErrSubscriptionNotFound
+    }
+    delete(r.subs, id)
+    sub.DeletedAt = &deletedAt
+    r.deleted[id] = sub
+    return nil
+}
+
+func (r *fakeSubscriptionRepo) Restore(ctx context.Context, id uuid.UUID) (*models.Subscription, error) {
+    r.restored = append(r.restored, id)
+    return r.deleted[id], nil
+}
+
+func (r *fakeSubscriptionRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+    r.purgedBefore = &deletedBefore
+    return 0, nil
+}
+
+func (r *fakeSubscriptionRepo) AddPriceChange(ctx context.Context, id uuid.UUID, change *models.PriceChange) error {
+    r.priceChanges = append(r.priceChanges, change)
+    return nil
+}
+
+// fakeCatalogRepo находит сервисы только по точному нормализованному названию
+type fakeCatalogRepo struct {
+    repository.CatalogRepository
+
+    services map[string]*models.Service
+}
+
+func (r *fakeCatalogRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Service, error) {
+    for _, svc := range r.services {
+        if svc.ID == id {
+            return svc, nil
+        }
+    }
+    return nil, repository.ErrServiceNotFound
+}
+
+func (r *fakeCatalogRepo) Resolve(ctx context.Context, name string) (*models.Service, error) {
+    svc, ok := r.services[models.NormalizeServiceName(name)]
+    if !ok {
+        return nil, repository.ErrServiceNotFound
+    }
+    return svc, nil
+}
+
+func newFakeCatalogRepo() *fakeCatalogRepo {
+    return &fakeCatalogRepo{services: map[string]*models.Service{
+        "yandex plus": {
+            ID:            uuid.New(),
+            Name:          "Yandex Plus",
+            DefaultPrices: models.DefaultPrices{models.BillingMonthly: 39900},
+            Currency:      "RUB",
+        },
+    }}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+    return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func intPtr(v int) *int {
+    return &v
+}
+
+func timePtr(t time.Time) *time.Time {
+    return &t
+}
+
+// invalidFields возвращает поля из *ValidationError или nil, если ошибка другого типа
+func invalidFields(t *testing.T, err error) []string {
+    t.Helper()
+
+    var validationErr *ValidationError
+    if !errors.As(err, &validationErr) {
+        return nil
+    }
+    if !errors.Is(err, ErrValidation) {
+        t.Errorf("ValidationError does not match ErrValidation")
+    }
+
+    fields := make([]string, len(validationErr.Fields))
+    for i, f := range validationErr.Fields {
+        fields[i] = f.Field
+    }
+    return fields
+}
+
+func validSubscription() *models.Subscription {
+    return &models.Subscription{
+        ServiceName:   "Netflix",
+        Price:         79900,
+        Currency:      "RUB",
+        BillingPeriod: models.BillingMonthly,
+        UserID:        uuid.New(),
+        StartDate:     date(2024, time.January, 1),
+    }
+}
+
+func TestCreateSubscriptionValidation(t *testing.T) {
+    tests := []struct {
+        name        string
+        modify      func(sub *models.Subscription)
+        activeCount int
+        wantFields  []string
+    }{
+        {
+            name:   "valid",
+            modify: func(sub *models.Subscription) {},
+        },
+        {
+            name:       "end date before start date",
+            modify:     func(sub *models.Subscription) { sub.EndDate = timePtr(date(2023, time.December, 31)) },
+            wantFields: []string{"end_date"},
+        },
+        {
+            name:   "end date equal to start date",
+            modify: func(sub *models.Subscription) { sub.EndDate = timePtr(sub.StartDate) },
+        },
+        {
+            name:       "start date in year 1",
+            modify:     func(sub *models.Subscription) { sub.StartDate = time.Time{} },
+            wantFields: []string{"start_date"},
+        },
+        {
+            name:       "price above maximum",
+            modify:     func(sub *models.Subscription) { sub.Price = 1000000000 },
+            wantFields: []string{"price"},
+        },
+        {
+            name:   "price at maximum",
+            modify: func(sub *models.Subscription) { sub.Price = maxPrice },
+        },
+        {
+            name:       "negative price",
+            modify:     func(sub *models.Subscription) { sub.Price = -100 },
+            wantFields: []string{"price"},
+        },
+        {
+            name:       "price missing and not in catalog",
+            modify:     func(sub *models.Subscription) { sub.Price = 0 },
+            wantFields: []string{"price"},
+        },
+        {
+            name:   "price taken from catalog",
+            modify: func(sub *models.Subscription) { sub.ServiceName, sub.Price = "yandex  plus", 0 },
+        },
+        {
+            name:       "service name too long",
+            modify:     func(sub *models.Subscription) { sub.ServiceName = strings.Repeat("я", maxServiceNameLength+1) },
+            wantFields: []string{"service_name"},
+        },
+        {
+            name:   "cyrillic service name",
+            modify: func(sub *models.Subscription) { sub.ServiceName = "Кинопоиск HD (семейная)" },
+        },
+        {
+            name:       "service name with markup",
+            modify:     func(sub *models.Subscription) { sub.ServiceName = "<b>Netflix</b>" },
+            wantFields: []string{"service_name"},
+        },
+        {
+            name:       "service name of punctuation only",
+            modify:     func(sub *models.Subscription) { sub.ServiceName = "+++" },
+            wantFields: []string{"service_name"},
+        },
+        {
+            name:       "blank service name",
+            modify:     func(sub *models.Subscription) { sub.ServiceName = "   " },
+            wantFields: []string{"service_name"},
+        },
+        {
+            name: "weekly anchor day out of range",
+            modify: func(sub *models.Subscription) {
+                sub.BillingPeriod = models.BillingWeekly
+                sub.BillingAnchorDay = intPtr(9)
+            },
+            wantFields: []string{"billing_anchor_day"},
+        },
+        {
+            name: "several violations at once",
+            modify: func(sub *models.Subscription) {
+                sub.Price = maxPrice + 1
+                sub.EndDate = timePtr(date(2020, time.January, 1))
+            },
+            wantFields: []string{"price", "end_date"},
+        },
+        {
+            name:        "user below limit",
+            modify:      func(sub *models.Subscription) {},
+            activeCount: maxSubscriptionsPerUser - 1,
+        },
+        {
+            name:        "user at limit",
+            modify:      func(sub *models.Subscription) {},
+            activeCount: maxSubscriptionsPerUser,
+            wantFields:  []string{"user_id"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo()
+            repo.activeCount = tt.activeCount
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            sub := validSubscription()
+            tt.modify(sub)
+
+            err := svc.CreateSubscription(context.Background(), sub)
+            if tt.wantFields == nil {
+                if err != nil {
+                    t.Fatalf("CreateSubscription() error = %v, want nil", err)
+                }
+                if len(repo.created) != 1 {
+                    t.Fatalf("repository Create called %d times, want 1", len(repo.created))
+                }
+                return
+            }
+
+            if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                t.Fatalf("CreateSubscription() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+            }
+            if len(repo.created) != 0 {
+                t.Fatalf("repository Create called for an invalid subscription")
+            }
+        })
+    }
+}
+
+func TestUpdateSubscriptionValidation(t *testing.T) {
+    current := validSubscription()
+    current.ID = uuid.New()
+
+    tests := []struct {
+        name        string
+        modify      func(req *models.UpdateSubscriptionRequest)
+        activeCount int
+        wantFields  []string
+    }{
+        {
+            name:   "valid",
+            modify: func(req *models.UpdateSubscriptionRequest) { req.Price = 89900 },
+        },
+        {
+            name:       "end date before start date",
+            modify:     func(req *models.UpdateSubscriptionRequest) { req.EndDate = timePtr(date(2023, time.June, 1)) },
+            wantFields: []string{"end_date"},
+        },
+        {
+            name:       "price removed",
+            modify:     func(req *models.UpdateSubscriptionRequest) { req.Price = 0 },
+            wantFields: []string{"price"},
+        },
+        {
+            name:        "same user over limit keeps editing",
+            modify:      func(req *models.UpdateSubscriptionRequest) { req.Price = 89900 },
+            activeCount: maxSubscriptionsPerUser + 5,
+        },
+        {
+            name:        "moving to user at limit",
+            modify:      func(req *models.UpdateSubscriptionRequest) { req.UserID = uuid.New() },
+            activeCount: maxSubscriptionsPerUser,
+            wantFields:  []string{"user_id"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo(current)
+            repo.activeCount = tt.activeCount
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            req := current.UpdateRequest()
+            tt.modify(&req)
+
+            _, err := svc.UpdateSubscription(context.Background(), current.ID, &req, nil)
+            if tt.wantFields == nil {
+                if err != nil {
+                    t.Fatalf("UpdateSubscription() error = %v, want nil", err)
+                }
+                if len(repo.updated) != 1 {
+                    t.Fatalf("repository Update called %d times, want 1", len(repo.updated))
+                }
+                return
+            }
+
+            if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                t.Fatalf("UpdateSubscription() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+            }
+            if len(repo.updated) != 0 {
+                t.Fatalf("repository Update called for an invalid subscription")
+            }
+        })
+    }
+}
+
+func TestUpsertSubscriptionUserLimit(t *testing.T) {
+    existing := validSubscription()
+    existing.ID = uuid.New()
+
+    tests := []struct {
+        name        string
+        modify      func(sub *models.Subscription)
+        activeCount int
+        wantCreated bool
+        wantFields  []string
+    }{
+        {
+            name:        "new key below limit",
+            modify:      func(sub *models.Subscription) { sub.StartDate = date(2024, time.June, 1) },
+            activeCount: maxSubscriptionsPerUser - 1,
+            wantCreated: true,
+        },
+        {
+            name:        "new key at limit",
+            modify:      func(sub *models.Subscription) { sub.StartDate = date(2024, time.June, 1) },
+            activeCount: maxSubscriptionsPerUser,
+            wantFields:  []string{"user_id"},
+        },
+        {
+            name:        "existing key at limit",
+            modify:      func(sub *models.Subscription) { sub.ServiceName, sub.Price = "NETFLIX", 89900 },
+            activeCount: maxSubscriptionsPerUser,
+        },
+        {
+            name:       "invalid replacement",
+            modify:     func(sub *models.Subscription) { sub.EndDate = timePtr(date(2023, time.January, 1)) },
+            wantFields: []string{"end_date"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo(existing)
+            repo.activeCount = tt.activeCount
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            sub := validSubscription()
+            sub.UserID = existing.UserID
+            tt.modify(sub)
+
+            created, err := svc.UpsertSubscription(context.Background(), sub)
+            if tt.wantFields != nil {
+                if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                    t.Fatalf("UpsertSubscription() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+                }
+                return
+            }
+
+            if err != nil {
+                t.Fatalf("UpsertSubscription() error = %v, want nil", err)
+            }
+            if created != tt.wantCreated {
+                t.Fatalf("UpsertSubscription() created = %t, want %t", created, tt.wantCreated)
+            }
+        })
+    }
+}
+
+func TestUpdateSubscriptionNotFound(t *testing.T) {
+    svc := NewSubscriptionService(newFakeSubscriptionRepo(), newFakeCatalogRepo())
+
+    req := validSubscription().UpdateRequest()
+    _, err := svc.UpdateSubscription(context.Background(), uuid.New(), &req, nil)
+    if !errors.Is(err, ErrNotFound) {
+        t.Fatalf("UpdateSubscription() error = %v, want ErrNotFound", err)
+    }
+}
+
+func TestPatchSubscriptionAnchorDay(t *testing.T) {
+    tests := []struct {
+        name       string
+        period     models.BillingPeriod
+        anchorDay  *int
+        patch      func(req *models.UpdateSubscriptionRequest)
+        wantFields []string
+    }{
+        {
+            name:   "anchor only within weekly period",
+            period: models.BillingWeekly,
+            patch:  func(req *models.UpdateSubscriptionRequest) { req.BillingAnchorDay = intPtr(7) },
+        },
+        {
+            name:       "anchor only beyond stored weekly period",
+            period:     models.BillingWeekly,
+            patch:      func(req *models.UpdateSubscriptionRequest) { req.BillingAnchorDay = intPtr(31) },
+            wantFields: []string{"billing_anchor_day"},
+        },
+        {
+            name:       "period only with stored monthly anchor",
+            period:     models.BillingMonthly,
+            anchorDay:  intPtr(20),
+            patch:      func(req *models.UpdateSubscriptionRequest) { req.BillingPeriod = models.BillingWeekly },
+            wantFields: []string{"billing_anchor_day"},
+        },
+        {
+            name:      "yearly keeps month anchor",
+            period:    models.BillingMonthly,
+            anchorDay: intPtr(31),
+            patch:     func(req *models.UpdateSubscriptionRequest) { req.BillingPeriod = models.BillingYearly },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            current := validSubscription()
+            current.ID = uuid.New()
+            current.BillingPeriod = tt.period
+            current.BillingAnchorDay = tt.anchorDay
+
+            repo := newFakeSubscriptionRepo(current)
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            patch := func(req *models.UpdateSubscriptionRequest) error {
+                tt.patch(req)
+                return nil
+            }
+            _, err := svc.PatchSubscription(context.Background(), current.ID, patch, nil)
+            if tt.wantFields == nil {
+                if err != nil {
+                    t.Fatalf("PatchSubscription() error = %v, want nil", err)
+                }
+                return
+            }
+
+            if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                t.Fatalf("PatchSubscription() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+            }
+            if len(repo.updated) != 0 {
+                t.Fatalf("repository Update called for an invalid subscription")
+            }
+        })
+    }
+}
+
+func TestDeleteSubscriptionDeletedAt(t *testing.T) {
+    past := time.Now().UTC().AddDate(0, 0, -3)
+    future := time.Now().UTC().AddDate(0, 0, 1)
+
+    tests := []struct {
+        name       string
+        deletedAt  *time.Time
+        wantFields []string
+    }{
+        {name: "now by default"},
+        {name: "in the past", deletedAt: &past},
+        {name: "in the future", deletedAt: &future, wantFields: []string{"deleted_at"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sub := validSubscription()
+            sub.ID = uuid.New()
+            repo := newFakeSubscriptionRepo(sub)
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            before := time.Now().UTC()
+            err := svc.DeleteSubscription(context.Background(), sub.ID, tt.deletedAt, nil)
+            if tt.wantFields != nil {
+                if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                    t.Fatalf("DeleteSubscription() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+                }
+                if len(repo.deleted) != 0 {
+                    t.Fatalf("repository Delete called for a future-dated delete")
+                }
+                return
+            }
+
+            if err != nil {
+                t.Fatalf("DeleteSubscription() error = %v, want nil", err)
+            }
+            deleted := repo.deleted[sub.ID]
+            if deleted == nil || deleted.DeletedAt == nil {
+                t.Fatalf("repository Delete not called")
+            }
+            if tt.deletedAt != nil {
+                if !deleted.DeletedAt.Equal(*tt.deletedAt) {
+                    t.Fatalf("deleted_at = %s, want %s", deleted.DeletedAt, tt.deletedAt)
+                }
+            } else if deleted.DeletedAt.Before(before) || deleted.DeletedAt.After(time.Now().UTC()) {
+                t.Fatalf("deleted_at = %s, want the current time", deleted.DeletedAt)
+            }
+        })
+    }
+}
+
+func TestRestoreSubscriptionUserLimit(t *testing.T) {
+    tests := []struct {
+        name        string
+        activeCount int
+        wantErr     bool
+    }{
+        {name: "below limit", activeCount: maxSubscriptionsPerUser - 1},
+        {name: "at limit", activeCount: maxSubscriptionsPerUser, wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            deleted := validSubscription()
+            deleted.ID = uuid.New()
+
+            repo := newFakeSubscriptionRepo()
+            repo.deleted[deleted.ID] = deleted
+            repo.activeCount = tt.activeCount
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            _, err := svc.RestoreSubscription(context.Background(), deleted.ID)
+            if tt.wantErr {
+                if got := invalidFields(t, err); !reflect.DeepEqual(got, []string{"user_id"}) {
+                    t.Fatalf("RestoreSubscription() invalid fields = %v (error %v), want [user_id]", got, err)
+                }
+                if len(repo.restored) != 0 {
+                    t.Fatalf("repository Restore called over the limit")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("RestoreSubscription() error = %v, want nil", err)
+            }
+        })
+    }
+}
+
+func TestUserLimitCheckedUnderLock(t *testing.T) {
+    existing := validSubscription()
+    existing.ID = uuid.New()
+
+    tests := []struct {
+        name string
+        call func(svc SubscriptionService, repo *fakeSubscriptionRepo) error
+    }{
+        {
+            name: "create",
+            call: func(svc SubscriptionService, repo *fakeSubscriptionRepo) error {
+                return svc.CreateSubscription(context.Background(), validSubscription())
+            },
+        },
+        {
+            name: "upsert",
+            call: func(svc SubscriptionService, repo *fakeSubscriptionRepo) error {
+                _, err := svc.UpsertSubscription(context.Background(), validSubscription())
+                return err
+            },
+        },
+        {
+            name: "update to another user",
+            call: func(svc SubscriptionService, repo *fakeSubscriptionRepo) error {
+                req := existing.UpdateRequest()
+                req.UserID = uuid.New()
+                _, err := svc.UpdateSubscription(context.Background(), existing.ID, &req, nil)
+                return err
+            },
+        },
+        {
+            name: "import",
+            call: func(svc SubscriptionService, repo *fakeSubscriptionRepo) error {
+                file := "service_name,price,user_id,start_date\nSpotify,299," + uuid.New().String() + ",2024-01-01\n"
+                _, err := svc.ImportSubscriptions(context.Background(), strings.NewReader(file), &models.ImportOptions{})
+                return err
+            },
+        },
+        {
+            name: "restore",
+            call: func(svc SubscriptionService, repo *fakeSubscriptionRepo) error {
+                deleted := validSubscription()
+                deleted.ID = uuid.New()
+                repo.deleted[deleted.ID] = deleted
+                _, err := svc.RestoreSubscription(context.Background(), deleted.ID)
+                return err
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo(existing)
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            if err := tt.call(svc, repo); err != nil {
+                t.Fatalf("error = %v, want nil", err)
+            }
+            if len(repo.locked) == 0 {
+                t.Fatalf("user limit checked without LockUsers")
+            }
+            if repo.countedUnlocked {
+                t.Fatalf("active subscriptions counted before the user was locked")
+            }
+        })
+    }
+}
+
+func TestPurgeDeletedValidation(t *testing.T) {
+    tests := []struct {
+        name          string
+        olderThanDays int
+        wantErr       bool
+    }{
+        {name: "today", olderThanDays: 0},
+        {name: "month ago", olderThanDays: 30},
+        {name: "future cutoff", olderThanDays: -1, wantErr: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo()
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            _, err := svc.PurgeDeleted(context.Background(), tt.olderThanDays)
+            if tt.wantErr {
+                if got := invalidFields(t, err); !reflect.DeepEqual(got, []string{"older_than_days"}) {
+                    t.Fatalf("PurgeDeleted() invalid fields = %v (error %v), want [older_than_days]", got, err)
+                }
+                if repo.purgedBefore != nil {
+                    t.Fatalf("repository Purge called with a future cutoff")
+                }
+                return
+            }
+
+            if err != nil {
+                t.Fatalf("PurgeDeleted() error = %v, want nil", err)
+            }
+            if repo.purgedBefore == nil || repo.purgedBefore.After(time.Now()) {
+                t.Fatalf("repository Purge cutoff = %v, want a moment in the past", repo.purgedBefore)
+            }
+        })
+    }
+}
+
+func TestSchedulePriceChangeValidation(t *testing.T) {
+    today := time.Now().UTC().Truncate(24 * time.Hour)
+
+    sub := validSubscription()
+    sub.ID = uuid.New()
+    sub.StartDate = today.AddDate(0, -6, 0)
+    sub.EndDate = timePtr(today.AddDate(0, 6, 0))
+
+    tests := []struct {
+        name       string
+        change     models.PriceChange
+        wantFields []string
+    }{
+        {
+            name:   "valid",
+            change: models.PriceChange{EffectiveFrom: today.AddDate(0, 1, 0), Price: 89900},
+        },
+        {
+            name:       "in the past",
+            change:     models.PriceChange{EffectiveFrom: today.AddDate(0, 0, -1), Price: 89900},
+            wantFields: []string{"effective_from"},
+        },
+        {
+            name:       "after end date",
+            change:     models.PriceChange{EffectiveFrom: today.AddDate(1, 0, 0), Price: 89900},
+            wantFields: []string{"effective_from"},
+        },
+        {
+            name:       "price above maximum",
+            change:     models.PriceChange{EffectiveFrom: today.AddDate(0, 1, 0), Price: maxPrice + 1},
+            wantFields: []string{"price"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo(sub)
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            change := tt.change
+            _, err := svc.SchedulePriceChange(context.Background(), sub.ID, &change)
+            if tt.wantFields == nil {
+                if err != nil {
+                    t.Fatalf("SchedulePriceChange() error = %v, want nil", err)
+                }
+                if len(repo.priceChanges) != 1 {
+                    t.Fatalf("repository AddPriceChange called %d times, want 1", len(repo.priceChanges))
+                }
+                return
+            }
+
+            if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                t.Fatalf("SchedulePriceChange() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+            }
+            if len(repo.priceChanges) != 0 {
+                t.Fatalf("repository AddPriceChange called for an invalid change")
+            }
+        })
+    }
+}
+
+func TestApplyBatch(t *testing.T) {
+    existing := validSubscription()
+    existing.ID = uuid.New()
+    missingID := uuid.New()
+
+    create := models.CreateSubscriptionRequest{
+        ServiceName: "Spotify",
+        Price:       29900,
+        Currency:    "RUB",
+        UserID:      uuid.New(),
+        StartDate:   date(2024, time.March, 1),
+    }
+    update := existing.UpdateRequest()
+    update.Price = 89900
+
+    // Вторая операция не находит подписку, четвертая не прошла проверку в обработчике
+    ops := func() []models.BatchOperation {
+        return []models.BatchOperation{
+            {Op: models.BatchCreate, Create: &create},
+            {Op: models.BatchUpdate, ID: &missingID, Update: &update},
+            {Op: models.BatchDelete, ID: &existing.ID},
+            {Op: models.BatchUpdate, Err: newValidationError("id", "required_unless", "is required for this operation")},
+        }
+    }
+
+    tests := []struct {
+        name          string
+        mode          models.BatchMode
+        wantMode      models.BatchMode
+        wantCommitted bool
+        wantErrs      []error
+        wantSucceeded int
+    }{
+        {
+            name:     "atomic by default",
+            wantMode: models.BatchAtomic,
+            wantErrs: []error{ErrBatchAborted, ErrNotFound, ErrBatchAborted, ErrValidation},
+        },
+        {
+            name:     "atomic",
+            mode:     models.BatchAtomic,
+            wantMode: models.BatchAtomic,
+            wantErrs: []error{ErrBatchAborted, ErrNotFound, ErrBatchAborted, ErrValidation},
+        },
+        {
+            name:          "best effort",
+            mode:          models.BatchBestEffort,
+            wantMode:      models.BatchBestEffort,
+            wantCommitted: true,
+            wantErrs:      []error{nil, ErrNotFound, nil, ErrValidation},
+            wantSucceeded: 2,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo(existing)
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            result, err := svc.ApplyBatch(context.Background(), tt.mode, ops())
+            if err != nil {
+                t.Fatalf("ApplyBatch() error = %v, want nil", err)
+            }
+
+            if result.Mode != tt.wantMode || result.Committed != tt.wantCommitted {
+                t.Fatalf("ApplyBatch() mode = %s, committed = %t, want %s, %t", result.Mode, result.Committed, tt.wantMode, tt.wantCommitted)
+            }
+            if result.Succeeded != tt.wantSucceeded || result.Failed != len(tt.wantErrs)-tt.wantSucceeded {
+                t.Fatalf("ApplyBatch() succeeded = %d, failed = %d, want %d, %d",
+                    result.Succeeded, result.Failed, tt.wantSucceeded, len(tt.wantErrs)-tt.wantSucceeded)
+            }
+
+            for i, item := range result.Results {
+                if item.Index != i {
+                    t.Errorf("result %d has index %d", i, item.Index)
+                }
+                if tt.wantErrs[i] == nil && item.Err != nil || !errors.Is(item.Err, tt.wantErrs[i]) {
+                    t.Errorf("result %d error = %v, want %v", i, item.Err, tt.wantErrs[i])
+                }
+                if item.Err != nil && item.Subscription != nil {
+                    t.Errorf("result %d has a subscription despite the error", i)
+                }
+            }
+
+            // Операция с ошибкой проверки не выполняется
+            if len(repo.updated) != 0 {
+                t.Fatalf("repository Update called %d times, want 0", len(repo.updated))
+            }
+        })
+    }
+}
+
+func TestApplyBatchTooManyOperations(t *testing.T) {
+    repo := newFakeSubscriptionRepo()
+    svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+    ops := make([]models.BatchOperation, maxBatchOperations+1)
+    _, err := svc.ApplyBatch(context.Background(), models.BatchAtomic, ops)
+    if got := invalidFields(t, err); !reflect.DeepEqual(got, []string{"operations"}) {
+        t.Fatalf("ApplyBatch() invalid fields = %v (error %v), want [operations]", got, err)
+    }
+}
+
+func TestImportSubscriptions(t *testing.T) {
+    const userID = "a1b2c3d4-e5f6-7890-abcd-ef1234567890"
+
+    tests := []struct {
+        name         string
+        file         string
+        opts         models.ImportOptions
+        wantImported int
+        wantRows     map[int][]string
+    }{
+        {
+            name: "default columns",
+            file: "service_name,price,user_id,start_date,end_date\n" +
+                "Netflix,799.00," + userID + ",2024-01-01,\n" +
+                "Spotify,299," + userID + ",2024-02-01,2024-12-31\n",
+            wantImported: 2,
+        },
+        {
+            name: "mapped columns with semicolons and spreadsheet formats",
+            file: "\ufeffСервис;Стоимость;Пользователь;Начало\n" +
+                "Netflix;1 299,50;" + userID + ";15.03.2024\n" +
+                "Spotify;299;" + userID + ";07-2024\n",
+            opts: models.ImportOptions{
+                Columns: map[string]string{
+                    "service_name": "сервис",
+                    "price":        "Стоимость",
+                    "user_id":      "Пользователь",
+                    "start_date":   "Начало",
+                },
+                Delimiter: ';',
+            },
+            wantImported: 2,
+        },
+        {
+            name: "row errors name file columns",
+            file: "Service,Price,User,Start,End\n" +
+                "Netflix,abc," + userID + ",2024-01-01,\n" +
+                "Netflix,799,not-a-uuid,01/02/2024,\n" +
+                "Netflix,799," + userID + ",2024-06-01,2024-01-01\n" +
+                "Yandex Plus,," + userID + ",2024-01-01,\n",
+            opts: models.ImportOptions{Columns: map[string]string{
+                "service_name": "Service",
+                "price":        "Price",
+                "user_id":      "User",
+                "start_date":   "Start",
+                "end_date":     "End",
+            }},
+            wantImported: 1,
+            wantRows: map[int][]string{
+                2: {"Price"},
+                3: {"User", "Start"},
+                4: {"End"},
+            },
+        },
+        {
+            name:         "dry run",
+            file:         "service_name,user_id,start_date,price\nNetflix," + userID + ",2024-01-01,799\n",
+            opts:         models.ImportOptions{DryRun: true},
+            wantImported: 1,
+        },
+        {
+            name:     "malformed row",
+            file:     "service_name,user_id,start_date,price\nNetflix," + userID + "\n",
+            wantRows: map[int][]string{2: nil},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            repo := newFakeSubscriptionRepo()
+            svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+            opts := tt.opts
+            result, err := svc.ImportSubscriptions(context.Background(), strings.NewReader(tt.file), &opts)
+            if err != nil {
+                t.Fatalf("ImportSubscriptions() error = %v, want nil", err)
+            }
+
+            wantRows := tt.wantImported + len(tt.wantRows)
+            if result.Rows != wantRows || result.Imported != tt.wantImported || result.Failed != len(tt.wantRows) {
+                t.Fatalf("ImportSubscriptions() rows = %d, imported = %d, failed = %d, want %d, %d, %d (errors %+v)",
+                    result.Rows, result.Imported, result.Failed, wantRows, tt.wantImported, len(tt.wantRows), result.Errors)
+            }
+            if result.DryRun != tt.opts.DryRun {
+                t.Fatalf("ImportSubscriptions() dry_run = %t, want %t", result.DryRun, tt.opts.DryRun)
+            }
+
+            for _, rowErr := range result.Errors {
+                wantFields, ok := tt.wantRows[rowErr.Row]
+                if !ok {
+                    t.Errorf("unexpected error in row %d: %+v", rowErr.Row, rowErr)
+                    continue
+                }
+                var fields []string
+                for _, f := range rowErr.Errors {
+                    fields = append(fields, f.Field)
+                }
+                if !reflect.DeepEqual(fields, wantFields) {
+                    t.Errorf("row %d invalid fields = %v, want %v", rowErr.Row, fields, wantFields)
+                }
+            }
+        })
+    }
+}
+
+func TestImportSubscriptionsChecks(t *testing.T) {
+    userID := uuid.New()
+    stored := &models.Subscription{
+        ID:            uuid.New(),
+        ServiceName:   "Netflix",
+        Price:         79900,
+        Currency:      "RUB",
+        BillingPeriod: models.BillingMonthly,
+        UserID:        userID,
+        StartDate:     date(2024, time.January, 1),
+    }
+    row := func(name, startDate string) string {
+        return name + ",299," + userID.String() + "," + startDate + "\n"
+    }
+    const header = "service_name,price,user_id,start_date\n"
+
+    // Повтор первой строки в следующей пачке: ее ключ надо помнить между пачками. У строк разные
+    // пользователи, чтобы файл не упирался в лимит.
+    first := "Spotify,299," + uuid.New().String() + ",2024-01-01\n"
+    nextBatch := header + first
+    for i := 1; i < importBatchSize; i++ {
+        nextBatch += "Spotify,299," + uuid.New().String() + ",2024-01-01\n"
+    }
+    nextBatch += first
+
+    tests := []struct {
+        name         string
+        file         string
+        activeCount  int
+        wantImported int
+        wantRows     map[int]string
+    }{
+        {
+            name:         "duplicates in database and in file",
+            file:         header + row("netflix", "2024-01-01") + row("Spotify", "2024-02-01") + row(" spotify ", "2024-02-01"),
+            wantImported: 1,
+            wantRows:     map[int]string{2: ErrSubscriptionExists.Error(), 4: ErrSubscriptionExists.Error()},
+        },
+        {
+            name:         "duplicate in next batch",
+            file:         nextBatch,
+            wantImported: importBatchSize,
+            wantRows:     map[int]string{importBatchSize + 2: ErrSubscriptionExists.Error()},
+        },
+        {
+            name:         "user limit counts accepted rows",
+            file:         header + row("Spotify", "2024-02-01") + row("Okko", "2024-02-01"),
+            activeCount:  maxSubscriptionsPerUser - 1,
+            wantImported: 1,
+            wantRows:     map[int]string{3: "Row failed validation"},
+        },
+    }
+
+    for _, tt := range tests {
+        for _, dryRun := range []bool{false, true} {
+            t.Run(fmt.Sprintf("%s dry_run=%t", tt.name, dryRun), func(t *testing.T) {
+                repo := newFakeSubscriptionRepo(stored)
+                repo.activeCount = tt.activeCount
+                svc := NewSubscriptionService(repo, newFakeCatalogRepo())
+
+                result, err := svc.ImportSubscriptions(context.Background(), strings.NewReader(tt.file), &models.ImportOptions{DryRun: dryRun})
+                if err != nil {
+                    t.Fatalf("ImportSubscriptions() error = %v, want nil", err)
+                }
+                if result.Imported != tt.wantImported || result.Failed != len(tt.wantRows) {
+                    t.Fatalf("ImportSubscriptions() imported = %d, failed = %d, want %d, %d (errors %+v)",
+                        result.Imported, result.Failed, tt.wantImported, len(tt.wantRows), result.Errors)
+                }
+                for _, rowErr := range result.Errors {
+                    if want, ok := tt.wantRows[rowErr.Row]; !ok || rowErr.Message != want {
+                        t.Errorf("row %d error = %q, want %q", rowErr.Row, rowErr.Message, want)
+                    }
+                }
+
+                wantCreated := tt.wantImported
+                if dryRun {
+                    wantCreated = 0
+                }
+                if len(repo.created) != wantCreated {
+                    t.Fatalf("created %d subscriptions, want %d", len(repo.created), wantCreated)
+                }
+                if dryRun && len(repo.locked) > 0 {
+                    t.Fatalf("dry run locked users")
+                }
+            })
+        }
+    }
+}
+
+func TestImportSubscriptionsColumns(t *testing.T) {
+    tests := []struct {
+        name       string
+        file       string
+        columns    map[string]string
+        wantFields []string
+    }{
+        {
+            name:       "empty file",
+            file:       "",
+            wantFields: []string{"file"},
+        },
+        {
+            name:       "required column missing",
+            file:       "service_name,price,start_date\n",
+            wantFields: []string{"columns[user_id]"},
+        },
+        {
+            name:       "mapped optional column missing",
+            file:       "service_name,user_id,start_date\n",
+            columns:    map[string]string{"price": "Цена"},
+            wantFields: []string{"columns[price]"},
+        },
+        {
+            name:       "unknown field",
+            file:       "service_name,user_id,start_date\n",
+            columns:    map[string]string{"currency": "Валюта"},
+            wantFields: []string{"columns[currency]"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            svc := NewSubscriptionService(newFakeSubscriptionRepo(), newFakeCatalogRepo())
+
+            _, err := svc.ImportSubscriptions(context.Background(), strings.NewReader(tt.file), &models.ImportOptions{Columns: tt.columns})
+            if got := invalidFields(t, err); !reflect.DeepEqual(got, tt.wantFields) {
+                t.Fatalf("ImportSubscriptions() invalid fields = %v (error %v), want %v", got, err, tt.wantFields)
+            }
+        })
+    }
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+    "fmt"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+
+    "subscription-service/internal/models"
+)
+
+// Бизнес-ограничения подписок
+const (
+    // maxPrice - 1 000 000.00 в любой валюте; большие суммы почти наверняка ошибка ввода
+    maxPrice = models.Money(1000000 * 100)
+    // maxServiceNameLength ограничивает название сервиса в символах
+    maxServiceNameLength = 100
+    // maxSubscriptionsPerUser ограничивает число действующих подписок одного пользователя
+    maxSubscriptionsPerUser = 100
+)
+
+// minStartDate - самая ранняя допустимая дата начала подписки
+var minStartDate = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// serviceNamePunctuation - знаки, допустимые в названии сервиса помимо букв, цифр и пробелов
+const serviceNamePunctuation = ".,-+&'!()_:/"
+
+// ValidationError перечисляет нарушенные бизнес-правила по полям запроса.
+// errors.Is(err, ErrValidation) для нее истинно.
+type ValidationError struct {
+    Fields []models.FieldError
+}
+
+func (e *ValidationError) Error() string {
+    msgs := make([]string, len(e.Fields))
+    for i, f := range e.Fields {
+        msgs[i] = f.Field + " " + f.Message
+    }
+    return fmt.Sprintf("%v: %s", ErrValidation, strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+    return ErrValidation
+}
+
+// fieldErrors накапливает нарушения, чтобы вернуть клиенту все сразу, а не по одному
+type fieldErrors []models.FieldError
+
+func (f *fieldErrors) add(field, rule, message string) {
+    *f = append(*f, models.FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// err возвращает *ValidationError или nil, если нарушений нет
+func (f fieldErrors) err() error {
+    if len(f) == 0 {
+        return nil
+    }
+    return &ValidationError{Fields: f}
+}
+
+// newValidationError возвращает ошибку с одним нарушением
+func newValidationError(field, rule, message string) error {
+    var errs fieldErrors
+    errs.add(field, rule, message)
+    return errs.err()
+}
+
+// validateSubscription проверяет поля подписки, которые не зависят от каталога и других подписок.
+// Нулевая цена допустима: при создании она берется из каталога, отсутствие цены проверяется после этого.
+func validateSubscription(sub *models.Subscription) error {
+    var errs fieldErrors
+
+    if sub.ServiceName != "" {
+        validateServiceName(&errs, sub.ServiceName)
+    }
+
+    validatePrice(&errs, "price", sub.Price)
+
+    if sub.BillingAnchorDay != nil && *sub.BillingAnchorDay > sub.BillingPeriod.MaxAnchorDay() {
+        errs.add("billing_anchor_day", "max", "must be a weekday (1-7) for weekly subscriptions")
+    }
+
+    if sub.StartDate.Before(minStartDate) {
+        errs.add("start_date", "min", fmt.Sprintf("must not be before %s", minStartDate.Format("2006-01-02")))
+    }
+    if sub.EndDate != nil && sub.EndDate.Before(sub.StartDate) {
+        errs.add("end_date", "gtefield", "must not be before start_date")
+    }
+
+    return errs.err()
+}
+
+func validateServiceName(errs *fieldErrors, name string) {
+    trimmed := strings.TrimSpace(name)
+    switch {
+    case trimmed == "":
+        errs.add("service_name", "required", "must not be blank")
+        return
+    case utf8.RuneCountInString(trimmed) > maxServiceNameLength:
+        errs.add("service_name", "max", fmt.Sprintf("must be at most %d characters long", maxServiceNameLength))
+        return
+    }
+
+    hasAlnum := false
+    for _, r := range trimmed {
+        switch {
+        case unicode.IsLetter(r), unicode.IsDigit(r):
+            hasAlnum = true
+        case r == ' ', strings.ContainsRune(serviceNamePunctuation, r):
+        default:
+            errs.add("service_name", "charset", fmt.Sprintf("may contain only letters, digits, spaces and %s", serviceNamePunctuation))
+            return
+        }
+    }
+    if !hasAlnum {
+        errs.add("service_name", "charset", "must contain at least one letter or digit")
+    }
+}
+
+func validatePrice(errs *fieldErrors, field string, price models.Money) {
+    switch {
+    case price < 0:
+        errs.add(field, "gt", "must be greater than 0")
+    case price > maxPrice:
+        errs.add(field, "max", fmt.Sprintf("must be at most %s", maxPrice))
+    }
+}
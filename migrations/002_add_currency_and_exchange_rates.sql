@@ -0,0 +1,13 @@
+ALTER TABLE subscriptions
+ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB';
+
+CREATE TABLE exchange_rates (
+    from_currency CHAR(3) NOT NULL,
+    to_currency CHAR(3) NOT NULL,
+    effective_date DATE NOT NULL,
+    rate DECIMAL(18,8) NOT NULL CHECK (rate > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (from_currency, to_currency, effective_date)
+);
+
+CREATE INDEX idx_exchange_rates_to_currency ON exchange_rates(to_currency, effective_date);
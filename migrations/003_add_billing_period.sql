@@ -0,0 +1,5 @@
+ALTER TABLE subscriptions
+ADD COLUMN billing_period VARCHAR(16) NOT NULL DEFAULT 'monthly'
+    CHECK (billing_period IN ('weekly', 'monthly', 'quarterly', 'yearly')),
+ADD COLUMN billing_anchor_day SMALLINT NULL
+    CHECK (billing_anchor_day BETWEEN 1 AND 31);
@@ -0,0 +1,8 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE subscriptions
+ADD COLUMN service_name_normalized VARCHAR(255)
+    GENERATED ALWAYS AS (btrim(lower(regexp_replace(service_name, '\s+', ' ', 'g')))) STORED;
+
+CREATE INDEX idx_subscriptions_service_name_trgm ON subscriptions USING GIN (service_name_normalized gin_trgm_ops);
+CREATE INDEX idx_subscriptions_user_service_normalized ON subscriptions (user_id, service_name_normalized, start_date);
@@ -0,0 +1,44 @@
+CREATE TABLE services (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    name_normalized VARCHAR(255)
+        GENERATED ALWAYS AS (btrim(lower(regexp_replace(name, '\s+', ' ', 'g')))) STORED,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    aliases_normalized TEXT[] NOT NULL DEFAULT '{}',
+    category VARCHAR(100) NULL,
+    vendor_url VARCHAR(2048) NULL,
+    default_prices JSONB NOT NULL DEFAULT '{}',
+    currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_services_name_normalized ON services(name_normalized);
+CREATE INDEX idx_services_aliases_normalized ON services USING GIN (aliases_normalized);
+CREATE INDEX idx_services_category ON services(category);
+
+ALTER TABLE subscriptions
+ADD COLUMN service_id UUID NULL REFERENCES services(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_subscriptions_service_id ON subscriptions(service_id);
+
+INSERT INTO services (name, aliases, category, vendor_url, default_prices) VALUES
+    ('Yandex Plus', ARRAY['Яндекс Плюс', 'Yandex Плюс', 'Яндекс.Плюс'], 'multimedia', 'https://plus.yandex.ru', '{"monthly": 399.00}'),
+    ('Spotify', ARRAY['Spotify Premium'], 'music', 'https://www.spotify.com', '{}'),
+    ('Netflix', ARRAY[]::TEXT[], 'video', 'https://www.netflix.com', '{}'),
+    ('Kinopoisk', ARRAY['Кинопоиск', 'КиноПоиск HD'], 'video', 'https://www.kinopoisk.ru', '{}'),
+    ('VK Music', ARRAY['VK Музыка', 'ВК Музыка', 'BOOM'], 'music', 'https://music.vk.com', '{}');
+
+UPDATE services
+SET aliases_normalized = ARRAY(
+    SELECT btrim(lower(regexp_replace(alias, '\s+', ' ', 'g')))
+    FROM unnest(aliases) AS alias
+);
+
+-- Привязываем существующие подписки к каталогу по каноническому названию или синониму
+UPDATE subscriptions s
+SET service_id = sv.id
+FROM services sv
+WHERE s.service_id IS NULL
+  AND (s.service_name_normalized = sv.name_normalized
+       OR s.service_name_normalized = ANY(sv.aliases_normalized));
@@ -0,0 +1,7 @@
+ALTER TABLE subscriptions ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE NULL;
+
+-- Удаленная подписка не должна мешать завести такую же заново
+ALTER TABLE subscriptions DROP CONSTRAINT unique_user_service_active;
+CREATE UNIQUE INDEX unique_user_service_active ON subscriptions (user_id, service_name, start_date) WHERE deleted_at IS NULL;
+
+CREATE INDEX idx_subscriptions_deleted_at ON subscriptions (deleted_at) WHERE deleted_at IS NOT NULL;
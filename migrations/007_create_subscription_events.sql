@@ -0,0 +1,13 @@
+-- Журнал изменений подписок. Внешнего ключа нет: история должна пережить окончательное удаление подписки.
+CREATE TABLE subscription_events (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id UUID NOT NULL,
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('create', 'update', 'delete', 'restore', 'purge')),
+    before JSONB NULL,
+    after JSONB NULL,
+    actor VARCHAR(255) NULL,
+    request_id VARCHAR(100) NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_subscription_events_subscription ON subscription_events (subscription_id, created_at);
@@ -0,0 +1,65 @@
+-- Версии строк subscriptions: каждая версия действует в интервале [valid_from, valid_to).
+-- Текущая версия имеет valid_to = NULL. Несколько изменений в одной транзакции дают версии
+-- нулевой длины (valid_from = valid_to), которые не попадают ни в один срез.
+CREATE TABLE subscription_versions (
+    version_id BIGSERIAL PRIMARY KEY,
+    id UUID NOT NULL,
+    service_id UUID NULL,
+    service_name VARCHAR(255) NOT NULL,
+    service_name_normalized VARCHAR(255)
+        GENERATED ALWAYS AS (btrim(lower(regexp_replace(service_name, '\s+', ' ', 'g')))) STORED,
+    price DECIMAL(10,2) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    billing_period VARCHAR(16) NOT NULL,
+    billing_anchor_day SMALLINT NULL,
+    user_id UUID NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE NULL,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE NULL,
+    valid_from TIMESTAMP WITH TIME ZONE NOT NULL,
+    valid_to TIMESTAMP WITH TIME ZONE NULL
+);
+
+CREATE INDEX idx_subscription_versions_id ON subscription_versions (id, valid_from);
+CREATE INDEX idx_subscription_versions_period ON subscription_versions (valid_from, valid_to);
+CREATE UNIQUE INDEX idx_subscription_versions_current ON subscription_versions (id) WHERE valid_to IS NULL;
+
+CREATE FUNCTION record_subscription_version() RETURNS trigger AS $$
+BEGIN
+    -- Окончательное удаление (purge) убирает подписку и из всех срезов
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM subscription_versions WHERE id = OLD.id;
+        RETURN OLD;
+    END IF;
+
+    IF TG_OP = 'UPDATE' THEN
+        UPDATE subscription_versions
+        SET valid_to = now()
+        WHERE id = OLD.id AND valid_to IS NULL;
+    END IF;
+
+    INSERT INTO subscription_versions (
+        id, service_id, service_name, price, currency, billing_period, billing_anchor_day,
+        user_id, start_date, end_date, created_at, updated_at, deleted_at, valid_from
+    ) VALUES (
+        NEW.id, NEW.service_id, NEW.service_name, NEW.price, NEW.currency, NEW.billing_period, NEW.billing_anchor_day,
+        NEW.user_id, NEW.start_date, NEW.end_date, NEW.created_at, NEW.updated_at, NEW.deleted_at, now()
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscriptions_versions
+AFTER INSERT OR UPDATE OR DELETE ON subscriptions
+FOR EACH ROW EXECUTE FUNCTION record_subscription_version();
+
+-- Для существующих подписок прошлые правки неизвестны: текущее состояние считается действующим с момента создания
+INSERT INTO subscription_versions (
+    id, service_id, service_name, price, currency, billing_period, billing_anchor_day,
+    user_id, start_date, end_date, created_at, updated_at, deleted_at, valid_from
+)
+SELECT id, service_id, service_name, price, currency, billing_period, billing_anchor_day,
+       user_id, start_date, end_date, created_at, updated_at, deleted_at, COALESCE(created_at, now())
+FROM subscriptions;
@@ -0,0 +1,13 @@
+-- Изменения цены подписки. Цена из subscriptions.price действует с начала подписки
+-- до первого изменения, каждое изменение - с effective_from до следующего.
+CREATE TABLE subscription_prices (
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    effective_from DATE NOT NULL,
+    price DECIMAL(10,2) NOT NULL CHECK (price > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (subscription_id, effective_from)
+);
+
+ALTER TABLE subscription_events DROP CONSTRAINT subscription_events_event_type_check;
+ALTER TABLE subscription_events ADD CONSTRAINT subscription_events_event_type_check
+    CHECK (event_type IN ('create', 'update', 'delete', 'restore', 'purge', 'price_change'));
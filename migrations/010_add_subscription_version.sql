@@ -0,0 +1,33 @@
+-- Номер версии строки для оптимистичной блокировки (ETag / If-Match); увеличивается при каждом изменении
+ALTER TABLE subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE subscription_versions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+UPDATE subscription_versions sv
+SET version = s.version
+FROM subscriptions s
+WHERE sv.id = s.id;
+
+CREATE OR REPLACE FUNCTION record_subscription_version() RETURNS trigger AS $$
+BEGIN
+    -- Окончательное удаление (purge) убирает подписку и из всех срезов
+    IF TG_OP = 'DELETE' THEN
+        DELETE FROM subscription_versions WHERE id = OLD.id;
+        RETURN OLD;
+    END IF;
+
+    IF TG_OP = 'UPDATE' THEN
+        UPDATE subscription_versions
+        SET valid_to = now()
+        WHERE id = OLD.id AND valid_to IS NULL;
+    END IF;
+
+    INSERT INTO subscription_versions (
+        id, service_id, service_name, price, currency, billing_period, billing_anchor_day,
+        user_id, start_date, end_date, created_at, updated_at, deleted_at, version, valid_from
+    ) VALUES (
+        NEW.id, NEW.service_id, NEW.service_name, NEW.price, NEW.currency, NEW.billing_period, NEW.billing_anchor_day,
+        NEW.user_id, NEW.start_date, NEW.end_date, NEW.created_at, NEW.updated_at, NEW.deleted_at, NEW.version, now()
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,8 @@
+-- Естественный ключ подписки - пользователь, сервис и дата начала. Название сравнивается в нормализованном виде,
+-- как и при поиске дубликатов, чтобы "Netflix" и " netflix" не заводились дважды.
+-- Перед миграцией такие дубликаты среди неудаленных подписок нужно удалить.
+DROP INDEX unique_user_service_active;
+CREATE UNIQUE INDEX unique_user_service_active ON subscriptions (user_id, service_name_normalized, start_date) WHERE deleted_at IS NULL;
+
+-- Индекс из 004 повторяет уникальный индекс
+DROP INDEX idx_subscriptions_user_service_normalized;
@@ -0,0 +1,12 @@
+-- Ответы на POST-запросы с заголовком Idempotency-Key. Пока запрос выполняется, status_code пуст.
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER NULL,
+    content_type VARCHAR(255) NULL,
+    response_body BYTEA NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
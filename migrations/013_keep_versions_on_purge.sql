@@ -0,0 +1,25 @@
+-- Окончательное удаление (purge) больше не стирает версии подписки: срезы на прошлые моменты должны
+-- оставаться такими, какими их уже видели отчеты. Текущая версия закрывается, и подписка пропадает
+-- только из срезов после удаления.
+CREATE OR REPLACE FUNCTION record_subscription_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN
+        UPDATE subscription_versions
+        SET valid_to = now()
+        WHERE id = OLD.id AND valid_to IS NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+
+    INSERT INTO subscription_versions (
+        id, service_id, service_name, price, currency, billing_period, billing_anchor_day,
+        user_id, start_date, end_date, created_at, updated_at, deleted_at, version, valid_from
+    ) VALUES (
+        NEW.id, NEW.service_id, NEW.service_name, NEW.price, NEW.currency, NEW.billing_period, NEW.billing_anchor_day,
+        NEW.user_id, NEW.start_date, NEW.end_date, NEW.created_at, NEW.updated_at, NEW.deleted_at, NEW.version, now()
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,8 @@
+-- Ключ идемпотентности действует в пределах метода и пути запроса: тот же ключ, отправленный
+-- на другой эндпоинт, не должен получить чужой сохраненный ответ.
+-- У старых ключей область пустая, поэтому они больше не совпадают с запросами и удаляются по истечении срока.
+ALTER TABLE idempotency_keys ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ALTER COLUMN scope DROP DEFAULT;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, key);
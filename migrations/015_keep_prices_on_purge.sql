@@ -0,0 +1,4 @@
+-- Изменения цены, как и версии подписки (013), переживают окончательное удаление подписки: срезы as_of
+-- на моменты до purge должны считать стоимость с теми же изменениями цены. Поэтому строки связаны
+-- с подпиской только по id, без каскадного удаления.
+ALTER TABLE subscription_prices DROP CONSTRAINT subscription_prices_subscription_id_fkey;